@@ -0,0 +1,44 @@
+// The commitstatus Lambda function posts a GitHub commit status for every
+// CodePipeline stage state change. provisionPipeline --commitStatus builds
+// and provisions it with the pipeline.
+//
+// To run it against a local stand-in for the GitHub API, pass a saved
+// event file:
+//
+//	GITHUB_API_URL=http://localhost:8080 GITHUB_TOKEN=local GITHUB_OWNER=owner GITHUB_REPO=repo \
+//	  go run ./cmd/commitstatus event.json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/mweagle/SpartaCodePipeline/pipeline"
+)
+
+func main() {
+	awsSession := session.Must(session.NewSession())
+	reporter := pipeline.NewCommitStatusReporter(awsSession)
+	if len(os.Args) < 2 {
+		lambda.Start(reporter.Handle)
+		return
+	}
+	eventBytes, eventBytesErr := ioutil.ReadFile(os.Args[1])
+	if eventBytesErr != nil {
+		log.Fatal(eventBytesErr)
+	}
+	var event pipeline.StageStateChangeEvent
+	unmarshalErr := json.Unmarshal(eventBytes, &event)
+	if unmarshalErr != nil {
+		log.Fatal(unmarshalErr)
+	}
+	handleErr := reporter.Handle(context.Background(), event)
+	if handleErr != nil {
+		log.Fatal(handleErr)
+	}
+}
@@ -0,0 +1,16 @@
+// The notifier Lambda function forwards the pipeline notifications
+// published to the notification topic to a Slack or Teams webhook.
+// provisionPipeline --notificationWebhook builds and provisions it with
+// the pipeline.
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/mweagle/SpartaCodePipeline/pipeline"
+)
+
+func main() {
+	awsSession := session.Must(session.NewSession())
+	lambda.Start(pipeline.NewNotificationFormatter(awsSession).Handle)
+}
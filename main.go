@@ -7,22 +7,40 @@ import (
 	sparta "github.com/mweagle/Sparta"
 	"github.com/mweagle/SpartaCodePipeline/pipeline"
 	"github.com/spf13/cobra"
-	"gopkg.in/go-playground/validator.v9"
 )
 
 // PipelineName is the name of the stack to provision that supports the pipeline
 var pipelineOptions pipeline.ProvisionOptions
 
-func init() {
-	sparta.RegisterCodePipelineEnvironment("test", map[string]string{
-		"MESSAGE":     "Hello Test!",
-		"ENVIRONMENT": "test",
-	})
-	sparta.RegisterCodePipelineEnvironment("production", map[string]string{
-		"MESSAGE":     "Hello Production!",
-		"ENVIRONMENT": "prod",
-	})
-}
+// deleteOptions are the options for the deletePipeline command
+var deleteOptions pipeline.DeleteOptions
+
+// statusOptions are the options for the pipelineStatus command
+var statusOptions pipeline.StatusOptions
+
+// approvalOptions are the options for the approve and reject commands
+var approvalOptions pipeline.ApprovalOptions
+
+// releaseOptions, stopOptions and retryOptions are the options for the
+// pipeline execution commands
+var releaseOptions pipeline.ReleaseOptions
+var stopOptions pipeline.StopOptions
+var retryOptions pipeline.RetryOptions
+
+// logsOptions are the options for the pipelineLogs command
+var logsOptions pipeline.LogsOptions
+
+// gcOptions are the options for the gcPipelines command
+var gcOptions pipeline.GCOptions
+
+// envDiffOptions are the options for the envDiff command
+var envDiffOptions pipeline.EnvDiffOptions
+
+// invokeLocalOptions are the options for the invokeLocal command
+var invokeLocalOptions pipeline.InvokeLocalOptions
+
+// requiredEnvironmentKeys are the variables every environment file defines
+var requiredEnvironmentKeys = []string{"MESSAGE", "ENVIRONMENT"}
 
 // Standard AWS Lambda function
 func helloSpartaWorld() (string, error) {
@@ -33,14 +51,21 @@ func helloSpartaWorld() (string, error) {
 	return messageText, nil
 }
 
+// lambdaFunctions are the service's functions, which invokeLocal can run
+var lambdaFunctions []*sparta.LambdaAWSInfo
+
 ////////////////////////////////////////////////////////////////////////////////
 // Add a command to provision a CI pipeline
 var pipelineProvisionCommand = &cobra.Command{
 	Use:   "provisionPipeline",
 	Short: "Provision a CI/CD pipeline for this stack",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		validate := validator.New()
-		cliErrors := validate.Struct(&pipelineOptions)
+		_, configErr := pipeline.ApplyLayeredConfig(cmd.Flags(),
+			cmd.PersistentFlags())
+		if configErr != nil {
+			return configErr
+		}
+		cliErrors := pipeline.ValidateOptions(&pipelineOptions)
 		if cliErrors != nil {
 			return cliErrors
 		}
@@ -49,28 +74,537 @@ var pipelineProvisionCommand = &cobra.Command{
 }
 
 ////////////////////////////////////////////////////////////////////////////////
-// Main
-func main() {
-	// Register the provisionPipeline command
-	pipelineProvisionCommand.PersistentFlags().StringVarP(&pipelineOptions.PipelineName, "pipeline", "p", "", "pipeline name")
-	pipelineProvisionCommand.PersistentFlags().StringVarP(&pipelineOptions.GithubRepo, "repo", "r", "", "GitHub Repo URL")
-	pipelineProvisionCommand.PersistentFlags().StringVarP(&pipelineOptions.GithubOAuthToken, "oauth", "o", "", "GitHub OAuth token")
-	pipelineProvisionCommand.PersistentFlags().StringVarP(&pipelineOptions.S3Bucket,
+// Add a command to delete the CI pipeline
+var pipelineDeleteCommand = &cobra.Command{
+	Use:   "deletePipeline",
+	Short: "Delete the CI/CD pipeline for this stack",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cliErrors := pipeline.ValidateOptions(&deleteOptions)
+		if cliErrors != nil {
+			return cliErrors
+		}
+		return pipeline.Delete(&deleteOptions)
+	},
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Add a command to report the CI pipeline state
+var pipelineStatusCommand = &cobra.Command{
+	Use:   "pipelineStatus",
+	Short: "Show the stages, actions and recent executions of the CI/CD pipeline",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cliErrors := pipeline.ValidateOptions(&statusOptions)
+		if cliErrors != nil {
+			return cliErrors
+		}
+		return pipeline.Status(&statusOptions, os.Stdout)
+	},
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Add commands to approve or reject a pending pipeline approval
+func newApprovalCommand(use string, short string, approved bool) *cobra.Command {
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cliErrors := pipeline.ValidateOptions(&approvalOptions)
+			if cliErrors != nil {
+				return cliErrors
+			}
+			return pipeline.Approve(&approvalOptions, approved, os.Stdout)
+		},
+	}
+}
+
+var pipelineApproveCommand = newApprovalCommand("approve",
+	"Approve a pending CI/CD pipeline approval",
+	true)
+var pipelineRejectCommand = newApprovalCommand("reject",
+	"Reject a pending CI/CD pipeline approval",
+	false)
+
+////////////////////////////////////////////////////////////////////////////////
+// Add commands to start, stop and retry pipeline executions
+var pipelineReleaseCommand = &cobra.Command{
+	Use:   "releasePipeline",
+	Short: "Start a CI/CD pipeline execution of the latest commit on the pipeline branch",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cliErrors := pipeline.ValidateOptions(&releaseOptions)
+		if cliErrors != nil {
+			return cliErrors
+		}
+		return pipeline.Release(&releaseOptions)
+	},
+}
+
+var pipelineStopCommand = &cobra.Command{
+	Use:   "stopPipeline",
+	Short: "Stop the in progress CI/CD pipeline execution",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cliErrors := pipeline.ValidateOptions(&stopOptions)
+		if cliErrors != nil {
+			return cliErrors
+		}
+		return pipeline.Stop(&stopOptions)
+	},
+}
+
+var pipelineGCCommand = &cobra.Command{
+	Use:   "gcPipelines",
+	Short: "Delete ephemeral CI/CD pipelines whose branch is gone or whose TTL has expired",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cliErrors := pipeline.ValidateOptions(&gcOptions)
+		if cliErrors != nil {
+			return cliErrors
+		}
+		return pipeline.GCPipelines(&gcOptions)
+	},
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Add a command to compare two pipeline environments
+var envDiffCommand = &cobra.Command{
+	Use:   "envDiff SOURCE TARGET",
+	Short: "Compare the variables and template configuration of two pipeline environments",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		envDiffOptions.Source = args[0]
+		envDiffOptions.Target = args[1]
+		cliErrors := pipeline.ValidateOptions(&envDiffOptions)
+		if cliErrors != nil {
+			return cliErrors
+		}
+		return pipeline.EnvDiff(&envDiffOptions, os.Stdout)
+	},
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Add a command to run a function locally under a pipeline environment
+var invokeLocalCommand = &cobra.Command{
+	Use:   "invokeLocal FUNCTION",
+	Short: "Run a Lambda function in-process with the variables of a pipeline environment",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		invokeLocalOptions.FunctionName = args[0]
+		cliErrors := pipeline.ValidateOptions(&invokeLocalOptions)
+		if cliErrors != nil {
+			return cliErrors
+		}
+		return pipeline.InvokeLocal(&invokeLocalOptions, lambdaFunctions, os.Stdout)
+	},
+}
+
+var pipelineRetryStageCommand = &cobra.Command{
+	Use:   "retryStage",
+	Short: "Retry the failed actions in a CI/CD pipeline stage",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cliErrors := pipeline.ValidateOptions(&retryOptions)
+		if cliErrors != nil {
+			return cliErrors
+		}
+		return pipeline.RetryStage(&retryOptions)
+	},
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Add a command to view the CodeBuild logs for a pipeline execution
+var pipelineLogsCommand = &cobra.Command{
+	Use:   "pipelineLogs",
+	Short: "Show the CodeBuild logs for the latest CI/CD pipeline build",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cliErrors := pipeline.ValidateOptions(&logsOptions)
+		if cliErrors != nil {
+			return cliErrors
+		}
+		return pipeline.Logs(&logsOptions, os.Stdout)
+	},
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Add a command to compare the generated pipeline template with the stack
+var pipelineDiffCommand = &cobra.Command{
+	Use:   "diffPipeline",
+	Short: "Compare the generated CI/CD pipeline template with the provisioned stack",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, configErr := pipeline.ApplyLayeredConfig(cmd.Flags(),
+			pipelineProvisionCommand.PersistentFlags())
+		if configErr != nil {
+			return configErr
+		}
+		cliErrors := pipeline.ValidateOptions(&pipelineOptions, "S3Bucket")
+		if cliErrors != nil {
+			return cliErrors
+		}
+		return pipeline.Diff(&pipelineOptions, os.Stdout)
+	},
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Add a command to show the effective provisionPipeline configuration
+var configCommand = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the layered CI/CD pipeline configuration",
+}
+
+var configShowCommand = &cobra.Command{
+	Use:   "show",
+	Short: "Show the effective provisionPipeline configuration and where each value came from",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// The provision flags are persistent flags, which cobra only merges
+		// into Flags() when that command runs
+		provisionFlags := pipelineProvisionCommand.PersistentFlags()
+		layeredConfig, configErr := pipeline.ApplyLayeredConfig(provisionFlags, provisionFlags)
+		if configErr != nil {
+			return configErr
+		}
+		return layeredConfig.WriteConfig(os.Stdout)
+	},
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Add the flags that define the pipeline template to a command
+func addProvisionFlags(command *cobra.Command) {
+	command.PersistentFlags().StringVarP(&pipelineOptions.PipelineName, "pipeline", "p", "", "pipeline name")
+	command.PersistentFlags().StringVarP(&pipelineOptions.GithubRepo, "repo", "r", "", "GitHub Repo URL")
+	command.PersistentFlags().StringVarP(&pipelineOptions.GithubOAuthToken, "oauth", "o", "", "GitHub OAuth token")
+	command.PersistentFlags().StringVarP(&pipelineOptions.S3Bucket,
 		"s3Bucket",
 		"s",
 		"",
 		"S3 Bucket to use for Lambda source")
-	pipelineProvisionCommand.PersistentFlags().BoolVarP(&pipelineOptions.Noop, "noop",
+	command.PersistentFlags().BoolVarP(&pipelineOptions.Noop, "noop",
 		"n",
 		false,
 		"Dry-run behavior only (do not perform mutations)")
+	command.PersistentFlags().BoolVarP(&pipelineOptions.Webhook,
+		"webhook",
+		"",
+		false,
+		"Trigger the pipeline with a GitHub webhook rather than polling")
+	command.PersistentFlags().StringVarP(&pipelineOptions.WebhookSecret,
+		"webhookSecret",
+		"",
+		"",
+		"Secret used to sign the GitHub webhook payloads. Required with --webhook")
+	command.PersistentFlags().StringVarP(&pipelineOptions.VpcID,
+		"vpcID",
+		"",
+		"",
+		"Optional VPC ID to attach the CodeBuild project to")
+	command.PersistentFlags().StringSliceVarP(&pipelineOptions.SubnetIDs,
+		"subnet",
+		"",
+		[]string{},
+		"VPC subnet ID for the CodeBuild project (repeatable)")
+	command.PersistentFlags().StringSliceVarP(&pipelineOptions.SecurityGroupIDs,
+		"securityGroup",
+		"",
+		[]string{},
+		"VPC security group ID for the CodeBuild project (repeatable)")
+	command.PersistentFlags().StringVarP(&pipelineOptions.GoPrivate,
+		"goPrivate",
+		"",
+		"",
+		"GOPRIVATE value for the CodeBuild environment")
+	command.PersistentFlags().StringVarP(&pipelineOptions.GoNoSumDB,
+		"goNoSumDB",
+		"",
+		"",
+		"GONOSUMDB value for the CodeBuild environment")
+	command.PersistentFlags().StringVarP(&pipelineOptions.GoProxy,
+		"goProxy",
+		"",
+		"",
+		"GOPROXY value for the CodeBuild environment")
+	command.PersistentFlags().StringVarP(&pipelineOptions.GitCredentialSecret,
+		"gitCredentialSecret",
+		"",
+		"",
+		"Secrets Manager name or ARN of the git credential used to fetch private modules")
+	command.PersistentFlags().StringVarP(&pipelineOptions.GitCredentialType,
+		"gitCredentialType",
+		"",
+		"token",
+		"Type of the git credential secret (token|ssh)")
+	command.PersistentFlags().StringArrayVarP(&pipelineOptions.Environments,
+		"environment",
+		"",
+		[]string{},
+		"name[:approval[:deployMode]] environment in promotion order (repeatable). Defaults to the registered environments")
+	command.PersistentFlags().StringVarP(&pipelineOptions.Branch,
+		"branch",
+		"",
+		"",
+		"Branch to build. Overrides the branch in the repository URL")
+	command.PersistentFlags().BoolVarP(&pipelineOptions.Ephemeral,
+		"ephemeral",
+		"",
+		false,
+		"Provision a build and test only pipeline, in a stack named after the branch, that gcPipelines deletes once the branch is gone")
+	command.PersistentFlags().BoolVarP(&pipelineOptions.PullRequests,
+		"pullRequests",
+		"",
+		false,
+		"Build and test pull requests and report their status to GitHub. Requires a CodeBuild GitHub source credential in the account and region")
+	command.PersistentFlags().BoolVarP(&pipelineOptions.CommitStatus,
+		"commitStatus",
+		"",
+		false,
+		"Post a GitHub commit status for every pipeline stage")
+	command.PersistentFlags().StringVarP(&pipelineOptions.GitHubTokenSecret,
+		"githubTokenSecret",
+		"",
+		"",
+		"Secrets Manager secret ARN with the GitHub token used for commit statuses")
+	command.PersistentFlags().StringVarP(&pipelineOptions.GitHubAPIURL,
+		"githubApiURL",
+		"",
+		"https://api.github.com",
+		"GitHub REST API endpoint the commit status function posts to, for GitHub Enterprise")
+	command.PersistentFlags().BoolVarP(&pipelineOptions.Notifications,
+		"notifications",
+		"",
+		false,
+		"Publish pipeline success and failure notifications to an SNS topic")
+	command.PersistentFlags().StringVarP(&pipelineOptions.NotificationWebhook,
+		"notificationWebhook",
+		"",
+		"",
+		"SSM parameter (ssm:/path) or Secrets Manager secret (secretsmanager:id[#key]) with the Slack or Teams incoming webhook URL that receives the notifications")
+	command.PersistentFlags().StringVarP(&pipelineOptions.NotificationFormat,
+		"notificationFormat",
+		"",
+		"slack",
+		"Notification webhook payload format (slack|teams)")
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Main
+func main() {
+	// Register the provisionPipeline command
+	addProvisionFlags(pipelineProvisionCommand)
+	pipelineProvisionCommand.PersistentFlags().BoolVarP(&pipelineOptions.Plan,
+		"plan",
+		"",
+		false,
+		"Preview the pipeline stack changes as a change set before applying them")
+	pipelineProvisionCommand.PersistentFlags().BoolVarP(&pipelineOptions.Yes,
+		"yes",
+		"y",
+		false,
+		"Execute the --plan change set without prompting for confirmation")
+	pipelineProvisionCommand.PersistentFlags().StringArrayVarP(&pipelineOptions.Tags,
+		"tag",
+		"",
+		[]string{},
+		"key=value tag for the pipeline stack and its resources (repeatable)")
+	pipelineProvisionCommand.PersistentFlags().BoolVarP(&pipelineOptions.TerminationProtection,
+		"terminationProtection",
+		"",
+		false,
+		"Enable termination protection for the pipeline and production stacks. Not supported for ephemeral pipelines")
+	pipelineProvisionCommand.PersistentFlags().StringVarP(&pipelineOptions.ReportPath,
+		"report",
+		"",
+		"./.sparta/pipeline-outputs.json",
+		"Path for the JSON report of the pipeline stack outputs")
+	pipelineProvisionCommand.PersistentFlags().StringVarP(&pipelineOptions.OutputPath,
+		"out",
+		"",
+		"",
+		"Path for the generated pipeline template (default ./.sparta/pipeline.<format>, - for stdout)")
+	pipelineProvisionCommand.PersistentFlags().StringVarP(&pipelineOptions.Format,
+		"format",
+		"",
+		"json",
+		"Generated pipeline template format (json|yaml)")
 	sparta.CommandLineOptions.Root.AddCommand(pipelineProvisionCommand)
 
+	// Register the config commands
+	configCommand.AddCommand(configShowCommand)
+	sparta.CommandLineOptions.Root.AddCommand(configCommand)
+
+	// Register the diffPipeline command
+	addProvisionFlags(pipelineDiffCommand)
+	sparta.CommandLineOptions.Root.AddCommand(pipelineDiffCommand)
+
+	// Register the deletePipeline command
+	pipelineDeleteCommand.PersistentFlags().StringVarP(&deleteOptions.PipelineName, "pipeline", "p", "", "pipeline name")
+	pipelineDeleteCommand.PersistentFlags().StringVarP(&deleteOptions.Branch,
+		"branch",
+		"",
+		"",
+		"Branch of the ephemeral pipeline to delete")
+	pipelineDeleteCommand.PersistentFlags().BoolVarP(&deleteOptions.IncludeEnvironments,
+		"include-environments",
+		"",
+		false,
+		"Also delete the Test and Production stacks deployed by the pipeline")
+	pipelineDeleteCommand.PersistentFlags().BoolVarP(&deleteOptions.PurgeBucket,
+		"purge-bucket",
+		"",
+		false,
+		"Empty and delete the retained artifact bucket")
+	pipelineDeleteCommand.PersistentFlags().BoolVarP(&deleteOptions.Force,
+		"force",
+		"",
+		false,
+		"Disable termination protection on protected stacks and delete them")
+	pipelineDeleteCommand.PersistentFlags().BoolVarP(&deleteOptions.Noop, "noop",
+		"n",
+		false,
+		"Dry-run behavior only (do not perform mutations)")
+	sparta.CommandLineOptions.Root.AddCommand(pipelineDeleteCommand)
+
+	// Register the pipelineStatus command
+	pipelineStatusCommand.PersistentFlags().StringVarP(&statusOptions.PipelineName, "pipeline", "p", "", "pipeline name")
+	pipelineStatusCommand.PersistentFlags().IntVarP(&statusOptions.Executions,
+		"executions",
+		"e",
+		5,
+		"Number of recent executions to show")
+	pipelineStatusCommand.PersistentFlags().StringVarP(&statusOptions.Output,
+		"output",
+		"",
+		"table",
+		"Output format (table|json)")
+	sparta.CommandLineOptions.Root.AddCommand(pipelineStatusCommand)
+
+	// Register the approve and reject commands
+	for _, eachCommand := range []*cobra.Command{pipelineApproveCommand, pipelineRejectCommand} {
+		eachCommand.PersistentFlags().StringVarP(&approvalOptions.PipelineName, "pipeline", "p", "", "pipeline name")
+		eachCommand.PersistentFlags().StringVarP(&approvalOptions.ActionName,
+			"action",
+			"a",
+			"",
+			"Approval action name (Approve). Defaults to the pending approval")
+		eachCommand.PersistentFlags().StringVarP(&approvalOptions.Summary,
+			"summary",
+			"m",
+			"",
+			"Comment to record with the approval result")
+		eachCommand.PersistentFlags().BoolVarP(&approvalOptions.Noop, "noop",
+			"n",
+			false,
+			"Dry-run behavior only (do not perform mutations)")
+		sparta.CommandLineOptions.Root.AddCommand(eachCommand)
+	}
+
+	// Register the execution commands
+	pipelineReleaseCommand.PersistentFlags().StringVarP(&releaseOptions.PipelineName, "pipeline", "p", "", "pipeline name")
+	pipelineReleaseCommand.PersistentFlags().BoolVarP(&releaseOptions.Noop, "noop",
+		"n",
+		false,
+		"Dry-run behavior only (do not perform mutations)")
+	sparta.CommandLineOptions.Root.AddCommand(pipelineReleaseCommand)
+
+	pipelineStopCommand.PersistentFlags().StringVarP(&stopOptions.PipelineName, "pipeline", "p", "", "pipeline name")
+	pipelineStopCommand.PersistentFlags().BoolVarP(&stopOptions.Abandon,
+		"abandon",
+		"",
+		false,
+		"Abandon in progress actions rather than waiting for them to finish")
+	pipelineStopCommand.PersistentFlags().StringVarP(&stopOptions.Reason,
+		"reason",
+		"",
+		"",
+		"Reason for stopping the execution")
+	pipelineStopCommand.PersistentFlags().BoolVarP(&stopOptions.Noop, "noop",
+		"n",
+		false,
+		"Dry-run behavior only (do not perform mutations)")
+	sparta.CommandLineOptions.Root.AddCommand(pipelineStopCommand)
+
+	pipelineRetryStageCommand.PersistentFlags().StringVarP(&retryOptions.PipelineName, "pipeline", "p", "", "pipeline name")
+	pipelineRetryStageCommand.PersistentFlags().StringVarP(&retryOptions.StageName,
+		"stage",
+		"",
+		"",
+		"Name of the failed stage to retry (eg: Build, TestStage, ProductionStage)")
+	pipelineRetryStageCommand.PersistentFlags().BoolVarP(&retryOptions.Noop, "noop",
+		"n",
+		false,
+		"Dry-run behavior only (do not perform mutations)")
+	sparta.CommandLineOptions.Root.AddCommand(pipelineRetryStageCommand)
+
+	// Register the pipelineLogs command
+	pipelineLogsCommand.PersistentFlags().StringVarP(&logsOptions.PipelineName, "pipeline", "p", "", "pipeline name")
+	pipelineLogsCommand.PersistentFlags().StringVarP(&logsOptions.ExecutionID,
+		"execution",
+		"e",
+		"",
+		"Pipeline execution ID. Defaults to the most recent execution")
+	pipelineLogsCommand.PersistentFlags().BoolVarP(&logsOptions.Follow,
+		"follow",
+		"",
+		false,
+		"Continue streaming the logs until the build completes")
+	sparta.CommandLineOptions.Root.AddCommand(pipelineLogsCommand)
+
+	// Register the gcPipelines command
+	pipelineGCCommand.PersistentFlags().DurationVarP(&gcOptions.TTL,
+		"ttl",
+		"",
+		0,
+		"Also delete ephemeral pipelines older than this duration (eg: 72h)")
+	pipelineGCCommand.PersistentFlags().StringVarP(&gcOptions.GithubOAuthToken, "oauth", "o", "", "GitHub OAuth token. Required to check the branches of a private repository")
+	pipelineGCCommand.PersistentFlags().BoolVarP(&gcOptions.PurgeBucket,
+		"purge-bucket",
+		"",
+		false,
+		"Also delete the retained artifact bucket of each deleted pipeline")
+	pipelineGCCommand.PersistentFlags().BoolVarP(&gcOptions.Noop, "noop",
+		"n",
+		false,
+		"Dry-run behavior only (do not perform mutations)")
+	sparta.CommandLineOptions.Root.AddCommand(pipelineGCCommand)
+
+	// Register the envDiff command
+	envDiffCommand.PersistentFlags().StringVarP(&envDiffOptions.ConfigDir,
+		"configDir",
+		"",
+		".sparta",
+		"Directory with the unzipped template configuration files")
+	sparta.CommandLineOptions.Root.AddCommand(envDiffCommand)
+
+	// Register the invokeLocal command
+	invokeLocalCommand.PersistentFlags().StringVarP(&invokeLocalOptions.Environment,
+		"env",
+		"",
+		"",
+		"Name of the pipeline environment")
+	invokeLocalCommand.PersistentFlags().StringVarP(&invokeLocalOptions.EventFile,
+		"event",
+		"",
+		"",
+		"Path of the JSON event to invoke the function with")
+	sparta.CommandLineOptions.Root.AddCommand(invokeLocalCommand)
+
+	if os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "" {
+		// Replace the secret references with their values at cold start
+		secretsErr := pipeline.ResolveSecretEnvironment()
+		if secretsErr != nil {
+			fmt.Fprintln(os.Stderr, secretsErr)
+			os.Exit(1)
+		}
+	} else {
+		// Register the environments/*.yaml pipeline environments. The
+		// Lambda package doesn't include them.
+		environmentsErr := pipeline.LoadEnvironments(pipeline.EnvironmentsDir,
+			requiredEnvironmentKeys...)
+		if environmentsErr != nil {
+			fmt.Fprintln(os.Stderr, environmentsErr)
+			os.Exit(1)
+		}
+	}
+
 	// Normal execution
-	lambdaFn := sparta.HandleAWSLambda("HelloWorld",
+	lambdaFn := pipeline.HandleAWSLambda("HelloWorld",
 		helloSpartaWorld,
 		sparta.IAMRoleDefinition{})
-	var lambdaFunctions []*sparta.LambdaAWSInfo
+	// Grant each environment's stack read access to its own secrets
+	lambdaFn.Decorator = pipeline.SecretPolicyDecorator
 	lambdaFunctions = append(lambdaFunctions, lambdaFn)
 	err := sparta.Main("SpartaCodePipeline",
 		fmt.Sprintf("SpartaCodePipeline CodePipeline example"),
@@ -0,0 +1,178 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/codepipeline"
+	"github.com/aws/aws-sdk-go/service/codepipeline/codepipelineiface"
+	"github.com/mweagle/Sparta"
+	spartaAWS "github.com/mweagle/Sparta/aws"
+	"github.com/sirupsen/logrus"
+)
+
+// ApprovalOptions are the command line options necessary to approve
+// or reject a pending pipeline approval
+type ApprovalOptions struct {
+	Noop         bool
+	PipelineName string `validate:"required"`
+	ActionName   string
+	Summary      string
+}
+
+// pendingApproval is an approval action that's waiting for a result
+type pendingApproval struct {
+	stageName  string
+	actionName string
+	token      string
+}
+
+// findPendingApproval returns the pending approval with the given action
+// name. If actionName is empty, the single pending approval is returned.
+func findPendingApproval(status *PipelineStatus,
+	actionName string) (*pendingApproval, error) {
+	candidates := make([]*pendingApproval, 0)
+	for _, eachStage := range status.Stages {
+		for _, eachAction := range eachStage.Actions {
+			if !eachAction.PendingApproval {
+				continue
+			}
+			if actionName != "" && eachAction.Name != actionName {
+				continue
+			}
+			candidates = append(candidates, &pendingApproval{
+				stageName:  eachStage.Name,
+				actionName: eachAction.Name,
+				token:      eachAction.ApprovalToken,
+			})
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		if actionName != "" {
+			return nil, fmt.Errorf("Approval action %s in pipeline %s is not pending",
+				actionName,
+				status.PipelineName)
+		}
+		return nil, fmt.Errorf("Pipeline %s has no pending approvals", status.PipelineName)
+	case 1:
+		return candidates[0], nil
+	default:
+		return nil, fmt.Errorf("Pipeline %s has %d pending approvals, please specify the action name",
+			status.PipelineName,
+			len(candidates))
+	}
+}
+
+// writeChangeSetSummary writes the changes in the change set that was
+// created earlier in the approval's stage, if there is one
+func writeChangeSetSummary(client codepipelineiface.CodePipelineAPI,
+	codePipelineName string,
+	approval *pendingApproval,
+	awsSession *session.Session,
+	writer io.Writer) error {
+	pipelineOutput, pipelineErr := client.GetPipeline(&codepipeline.GetPipelineInput{
+		Name: aws.String(codePipelineName),
+	})
+	if pipelineErr != nil {
+		return pipelineErr
+	}
+	stackName := ""
+	changeSetName := ""
+	for _, eachStage := range pipelineOutput.Pipeline.Stages {
+		if aws.StringValue(eachStage.Name) != approval.stageName {
+			continue
+		}
+		for _, eachAction := range eachStage.Actions {
+			if aws.StringValue(eachAction.Configuration["ActionMode"]) == "CHANGE_SET_REPLACE" {
+				stackName = aws.StringValue(eachAction.Configuration["StackName"])
+				changeSetName = aws.StringValue(eachAction.Configuration["ChangeSetName"])
+			}
+		}
+	}
+	if changeSetName == "" {
+		return nil
+	}
+	cfSvc := cloudformation.New(awsSession)
+	changeSetOutput, changeSetErr := cfSvc.DescribeChangeSet(&cloudformation.DescribeChangeSetInput{
+		StackName:     aws.String(stackName),
+		ChangeSetName: aws.String(changeSetName),
+	})
+	if changeSetErr != nil {
+		return changeSetErr
+	}
+	return writeChangeSet(changeSetOutput, writer)
+}
+
+// Approve is responsible for approving or rejecting a pending approval
+// action in the provisioned CI/CD pipeline
+func Approve(approvalOptions *ApprovalOptions,
+	approved bool,
+	writer io.Writer) error {
+	logger, loggerErr := sparta.NewLogger("info")
+	if loggerErr != nil {
+		return loggerErr
+	}
+	awsSession := spartaAWS.NewSession(logger)
+	codePipelineName, codePipelineNameErr := resolvePipelineName(approvalOptions.PipelineName,
+		awsSession)
+	if codePipelineNameErr != nil {
+		return codePipelineNameErr
+	}
+	client := codepipeline.New(awsSession)
+	status, statusErr := fetchPipelineStatus(client, codePipelineName, 0)
+	if statusErr != nil {
+		return statusErr
+	}
+	approval, approvalErr := findPendingApproval(status, approvalOptions.ActionName)
+	if approvalErr != nil {
+		return approvalErr
+	}
+	summaryErr := writeChangeSetSummary(client,
+		codePipelineName,
+		approval,
+		awsSession,
+		writer)
+	if summaryErr != nil {
+		return summaryErr
+	}
+
+	result := codepipeline.ApprovalStatusApproved
+	if !approved {
+		result = codepipeline.ApprovalStatusRejected
+	}
+	summary := approvalOptions.Summary
+	if summary == "" {
+		summary = fmt.Sprintf("%s from the %s command line",
+			result,
+			sparta.OptionsGlobal.ServiceName)
+	}
+	logFields := logrus.Fields{
+		"Pipeline": codePipelineName,
+		"Stage":    approval.stageName,
+		"Action":   approval.actionName,
+		"Result":   result,
+	}
+	if approvalOptions.Noop {
+		logger.WithFields(logFields).Info("Bypassing approval result due to --noop flag")
+		return nil
+	}
+	_, putErr := client.PutApprovalResult(&codepipeline.PutApprovalResultInput{
+		PipelineName: aws.String(codePipelineName),
+		StageName:    aws.String(approval.stageName),
+		ActionName:   aws.String(approval.actionName),
+		Token:        aws.String(approval.token),
+		Result: &codepipeline.ApprovalResult{
+			Status:  aws.String(result),
+			Summary: aws.String(summary),
+		},
+	})
+	if putErr != nil {
+		return putErr
+	}
+	logger.WithFields(logFields).Info("Approval result submitted")
+	return nil
+}
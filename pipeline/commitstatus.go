@@ -0,0 +1,210 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/codepipeline"
+	"github.com/aws/aws-sdk-go/service/codepipeline/codepipelineiface"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+// Environment variables that configure the commit status Lambda function
+const (
+	envGitHubAPIURL      = "GITHUB_API_URL"
+	envGitHubOwner       = "GITHUB_OWNER"
+	envGitHubRepo        = "GITHUB_REPO"
+	envGitHubTokenSecret = "GITHUB_TOKEN_SECRET"
+	envGitHubToken       = "GITHUB_TOKEN"
+)
+
+// githubBaseURL returns the GitHub REST API endpoint. GITHUB_API_URL
+// overrides it for GitHub Enterprise or a local stand-in. Provision sets
+// it for the commit status function from the --githubApiURL option.
+func githubBaseURL() string {
+	if baseURL := os.Getenv(envGitHubAPIURL); baseURL != "" {
+		return strings.TrimSuffix(baseURL, "/")
+	}
+	return githubAPIURL
+}
+
+// StageStateChangeEvent is the CloudWatch Events envelope of a
+// CodePipeline Stage Execution State Change event
+type StageStateChangeEvent struct {
+	DetailType string                 `json:"detail-type"`
+	Source     string                 `json:"source"`
+	Region     string                 `json:"region"`
+	Detail     stageStateChangeDetail `json:"detail"`
+}
+
+// stageStateChangeDetail is the detail of a CodePipeline Stage Execution
+// State Change event
+type stageStateChangeDetail struct {
+	Pipeline    string `json:"pipeline"`
+	ExecutionID string `json:"execution-id"`
+	Stage       string `json:"stage"`
+	State       string `json:"state"`
+}
+
+// commitStatus is the GitHub commit status request body
+type commitStatus struct {
+	State       string `json:"state"`
+	TargetURL   string `json:"target_url,omitempty"`
+	Description string `json:"description"`
+	Context     string `json:"context"`
+}
+
+// commitStatusStates maps CodePipeline stage states to GitHub commit
+// status states
+var commitStatusStates = map[string]string{
+	"STARTED":    "pending",
+	"RESUMED":    "pending",
+	"SUCCEEDED":  "success",
+	"FAILED":     "failure",
+	"CANCELED":   "error",
+	"SUPERSEDED": "error",
+	"STOPPED":    "error",
+}
+
+// CommitStatusReporter posts a GitHub commit status on the source revision
+// for every CodePipeline stage state change
+type CommitStatusReporter struct {
+	// BaseURL is the GitHub REST API endpoint
+	BaseURL string
+	Owner   string
+	Repo    string
+	// Token returns the GitHub token
+	Token        func() (string, error)
+	Client       *http.Client
+	CodePipeline codepipelineiface.CodePipelineAPI
+}
+
+// NewCommitStatusReporter returns a reporter configured by the
+// GITHUB_* environment variables. The token is read from Secrets Manager
+// once per container, unless GITHUB_TOKEN provides it for local runs.
+func NewCommitStatusReporter(awsSession *session.Session) *CommitStatusReporter {
+	secretID := os.Getenv(envGitHubTokenSecret)
+	var tokenOnce sync.Once
+	token := os.Getenv(envGitHubToken)
+	var tokenErr error
+	return &CommitStatusReporter{
+		BaseURL: githubBaseURL(),
+		Owner:   os.Getenv(envGitHubOwner),
+		Repo:    os.Getenv(envGitHubRepo),
+		Token: func() (string, error) {
+			tokenOnce.Do(func() {
+				if token == "" {
+					token, tokenErr = secretString(secretID, awsSession)
+				}
+			})
+			return token, tokenErr
+		},
+		Client:       &http.Client{Timeout: 10 * time.Second},
+		CodePipeline: codepipeline.New(awsSession),
+	}
+}
+
+// secretString returns the string value of the Secrets Manager secret
+func secretString(secretID string, awsSession *session.Session) (string, error) {
+	if secretID == "" {
+		return "", fmt.Errorf("%s is not set", envGitHubTokenSecret)
+	}
+	secretsSvc := secretsmanager.New(awsSession)
+	secretOutput, secretErr := secretsSvc.GetSecretValue(&secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(secretID),
+	})
+	if secretErr != nil {
+		return "", secretErr
+	}
+	return strings.TrimSpace(aws.StringValue(secretOutput.SecretString)), nil
+}
+
+// sourceRevision returns the commit SHA the pipeline execution is building
+func (reporter *CommitStatusReporter) sourceRevision(pipelineName string,
+	executionID string) (string, error) {
+	executionOutput, executionErr := reporter.CodePipeline.GetPipelineExecution(&codepipeline.GetPipelineExecutionInput{
+		PipelineName:        aws.String(pipelineName),
+		PipelineExecutionId: aws.String(executionID),
+	})
+	if executionErr != nil {
+		return "", executionErr
+	}
+	for _, eachRevision := range executionOutput.PipelineExecution.ArtifactRevisions {
+		if revisionID := aws.StringValue(eachRevision.RevisionId); revisionID != "" {
+			return revisionID, nil
+		}
+	}
+	return "", fmt.Errorf("Execution %s of pipeline %s has no source revision",
+		executionID,
+		pipelineName)
+}
+
+// postStatus creates the commit status for the revision
+func (reporter *CommitStatusReporter) postStatus(revision string, status *commitStatus) error {
+	token, tokenErr := reporter.Token()
+	if tokenErr != nil {
+		return tokenErr
+	}
+	body, bodyErr := json.Marshal(status)
+	if bodyErr != nil {
+		return bodyErr
+	}
+	statusURL := fmt.Sprintf("%s/repos/%s/%s/statuses/%s",
+		reporter.BaseURL,
+		url.PathEscape(reporter.Owner),
+		url.PathEscape(reporter.Repo),
+		url.PathEscape(revision))
+	request, requestErr := http.NewRequest(http.MethodPost, statusURL, bytes.NewReader(body))
+	if requestErr != nil {
+		return requestErr
+	}
+	request.Header.Set("Accept", "application/vnd.github.v3+json")
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "token "+token)
+	response, responseErr := reporter.Client.Do(request)
+	if responseErr != nil {
+		return responseErr
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		return fmt.Errorf("Failed to create %s commit status for %s: %s",
+			status.Context,
+			revision,
+			response.Status)
+	}
+	return nil
+}
+
+// Handle posts the commit status for a CodePipeline Stage Execution
+// State Change event. States without a GitHub equivalent are ignored.
+func (reporter *CommitStatusReporter) Handle(ctx context.Context,
+	event StageStateChangeEvent) error {
+	detail := event.Detail
+	state, stateOk := commitStatusStates[detail.State]
+	if !stateOk {
+		return nil
+	}
+	revision, revisionErr := reporter.sourceRevision(detail.Pipeline, detail.ExecutionID)
+	if revisionErr != nil {
+		return revisionErr
+	}
+	return reporter.postStatus(revision, &commitStatus{
+		State: state,
+		TargetURL: fmt.Sprintf("https://console.aws.amazon.com/codesuite/codepipeline/pipelines/%s/executions/%s/timeline?region=%s",
+			detail.Pipeline,
+			detail.ExecutionID,
+			event.Region),
+		Description: fmt.Sprintf("%s %s", detail.Stage, strings.ToLower(detail.State)),
+		Context:     fmt.Sprintf("codepipeline/%s", detail.Stage),
+	})
+}
@@ -0,0 +1,140 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codepipeline"
+	"github.com/aws/aws-sdk-go/service/codepipeline/codepipelineiface"
+)
+
+// mockExecutionCodePipeline returns a pipeline execution that built the
+// revision
+type mockExecutionCodePipeline struct {
+	codepipelineiface.CodePipelineAPI
+	revision string
+}
+
+func (mock *mockExecutionCodePipeline) GetPipelineExecution(input *codepipeline.GetPipelineExecutionInput) (*codepipeline.GetPipelineExecutionOutput, error) {
+	return &codepipeline.GetPipelineExecutionOutput{
+		PipelineExecution: &codepipeline.PipelineExecution{
+			PipelineName:        input.PipelineName,
+			PipelineExecutionId: input.PipelineExecutionId,
+			ArtifactRevisions: []*codepipeline.ArtifactRevision{
+				{RevisionId: aws.String(mock.revision)},
+			},
+		},
+	}, nil
+}
+
+func TestCommitStatusReporterHandle(t *testing.T) {
+	tests := []struct {
+		stageState string
+		// expectedState is the GitHub commit status state, or the empty
+		// string if no status is posted
+		expectedState string
+	}{
+		{"STARTED", "pending"},
+		{"RESUMED", "pending"},
+		{"SUCCEEDED", "success"},
+		{"FAILED", "failure"},
+		{"CANCELED", "error"},
+		{"STOPPED", "error"},
+		{"STOPPING", ""},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.stageState, func(t *testing.T) {
+			var posted *commitStatus
+			var postedPath string
+			var authorization string
+			server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				postedPath = request.URL.Path
+				authorization = request.Header.Get("Authorization")
+				posted = &commitStatus{}
+				decodeErr := json.NewDecoder(request.Body).Decode(posted)
+				if decodeErr != nil {
+					t.Error(decodeErr)
+				}
+				writer.WriteHeader(http.StatusCreated)
+			}))
+			defer server.Close()
+
+			reporter := &CommitStatusReporter{
+				BaseURL: server.URL,
+				Owner:   "owner",
+				Repo:    "repo",
+				Token: func() (string, error) {
+					return "test-token", nil
+				},
+				Client:       server.Client(),
+				CodePipeline: &mockExecutionCodePipeline{revision: "0123456789abcdef"},
+			}
+			handleErr := reporter.Handle(context.Background(), StageStateChangeEvent{
+				Region: "us-west-2",
+				Detail: stageStateChangeDetail{
+					Pipeline:    "pipeline",
+					ExecutionID: "execution-1",
+					Stage:       "Build",
+					State:       eachTest.stageState,
+				},
+			})
+			if handleErr != nil {
+				t.Fatal(handleErr)
+			}
+			if eachTest.expectedState == "" {
+				if posted != nil {
+					t.Errorf("Posted %+v for stage state %s", posted, eachTest.stageState)
+				}
+				return
+			}
+			if posted == nil {
+				t.Fatalf("No commit status posted for stage state %s", eachTest.stageState)
+			}
+			if posted.State != eachTest.expectedState {
+				t.Errorf("Commit status state %q, expected %q", posted.State, eachTest.expectedState)
+			}
+			if posted.Context != "codepipeline/Build" {
+				t.Errorf("Commit status context %q", posted.Context)
+			}
+			if postedPath != "/repos/owner/repo/statuses/0123456789abcdef" {
+				t.Errorf("Commit status posted to %s", postedPath)
+			}
+			if authorization != "token test-token" {
+				t.Errorf("Authorization header %q", authorization)
+			}
+		})
+	}
+}
+
+func TestCommitStatusReporterHandleError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+	}))
+	defer server.Close()
+
+	reporter := &CommitStatusReporter{
+		BaseURL: server.URL,
+		Owner:   "owner",
+		Repo:    "repo",
+		Token: func() (string, error) {
+			return "test-token", nil
+		},
+		Client:       server.Client(),
+		CodePipeline: &mockExecutionCodePipeline{revision: "0123456789abcdef"},
+	}
+	handleErr := reporter.Handle(context.Background(), StageStateChangeEvent{
+		Detail: stageStateChangeDetail{
+			Pipeline:    "pipeline",
+			ExecutionID: "execution-1",
+			Stage:       "Build",
+			State:       "SUCCEEDED",
+		},
+	})
+	if handleErr == nil {
+		t.Error("Expected an error for a rejected commit status")
+	}
+}
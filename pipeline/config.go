@@ -0,0 +1,192 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"unicode"
+
+	"github.com/ghodss/yaml"
+	"github.com/spf13/pflag"
+)
+
+// ProjectConfigFile is the project level configuration file name
+const ProjectConfigFile = ".spartapipeline.yaml"
+
+// EnvironmentPrefix is the prefix for configuration environment variables
+const EnvironmentPrefix = "SPARTA_PIPELINE_"
+
+// Configuration sources, in increasing order of precedence
+const (
+	configSourceDefault = "default"
+	configSourceUser    = "user"
+	configSourceProject = "project"
+	configSourceEnv     = "env"
+	configSourceFlag    = "flag"
+)
+
+// secretFlags are the flags whose values are redacted by WriteConfig
+var secretFlags = map[string]bool{
+	"oauth":         true,
+	"webhookSecret": true,
+}
+
+// userConfigPath returns the path of the user level configuration file
+func userConfigPath() string {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(configHome, "spartapipeline", "config.yaml")
+}
+
+// environmentVariableName returns the environment variable for the flag,
+// eg: s3Bucket => SPARTA_PIPELINE_S3_BUCKET
+func environmentVariableName(flagName string) string {
+	envName := make([]rune, 0, len(flagName)+len(EnvironmentPrefix))
+	var previous rune
+	for _, eachRune := range flagName {
+		switch {
+		case eachRune == '-':
+			envName = append(envName, '_')
+		case unicode.IsUpper(eachRune) &&
+			(unicode.IsLower(previous) || unicode.IsDigit(previous)):
+			envName = append(envName, '_', eachRune)
+		default:
+			envName = append(envName, unicode.ToUpper(eachRune))
+		}
+		previous = eachRune
+	}
+	return EnvironmentPrefix + string(envName)
+}
+
+// readConfigFile returns the flag values in the YAML file, keyed by
+// flag name. A missing file is not an error.
+func readConfigFile(configPath string) (map[string]interface{}, error) {
+	configBytes, configBytesErr := ioutil.ReadFile(configPath)
+	if os.IsNotExist(configBytesErr) {
+		return nil, nil
+	}
+	if configBytesErr != nil {
+		return nil, configBytesErr
+	}
+	values := make(map[string]interface{})
+	unmarshalErr := yaml.Unmarshal(configBytes, &values)
+	if unmarshalErr != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %s", configPath, unmarshalErr)
+	}
+	return values, nil
+}
+
+// setFlagValue sets a flag from a configuration file value. List values
+// are applied one element at a time so repeatable flags accumulate.
+func setFlagValue(flag *pflag.Flag, value interface{}) error {
+	switch typedValue := value.(type) {
+	case []interface{}:
+		for _, eachValue := range typedValue {
+			setErr := flag.Value.Set(fmt.Sprintf("%v", eachValue))
+			if setErr != nil {
+				return setErr
+			}
+		}
+		return nil
+	default:
+		return flag.Value.Set(fmt.Sprintf("%v", typedValue))
+	}
+}
+
+// LayeredConfig records where each flag value came from
+type LayeredConfig struct {
+	flags   *pflag.FlagSet
+	sources map[string]string
+}
+
+// ApplyLayeredConfig sets every flag that wasn't provided on the command
+// line from, in order of precedence, the SPARTA_PIPELINE_* environment
+// variables, the project configuration file and the user configuration
+// file. Flags without a value in any layer keep their default.
+// Configuration file keys must match a flag in either flags or knownFlags,
+// the provisionPipeline flags that the configuration files are written
+// for. Keys that only match knownFlags are ignored, so that commands which
+// define a subset of the provisionPipeline flags share the same files.
+func ApplyLayeredConfig(flags *pflag.FlagSet, knownFlags *pflag.FlagSet) (*LayeredConfig, error) {
+	userValues, userErr := readConfigFile(userConfigPath())
+	if userErr != nil {
+		return nil, userErr
+	}
+	projectValues, projectErr := readConfigFile(ProjectConfigFile)
+	if projectErr != nil {
+		return nil, projectErr
+	}
+	// Reject keys that don't match a flag so that typos aren't silently ignored
+	for _, eachValues := range []map[string]interface{}{userValues, projectValues} {
+		for eachKey := range eachValues {
+			if flags.Lookup(eachKey) == nil && knownFlags.Lookup(eachKey) == nil {
+				return nil, fmt.Errorf("Unknown configuration key: %s", eachKey)
+			}
+		}
+	}
+	config := &LayeredConfig{
+		flags:   flags,
+		sources: make(map[string]string),
+	}
+	var applyErr error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if applyErr != nil {
+			return
+		}
+		if flag.Changed {
+			config.sources[flag.Name] = configSourceFlag
+			return
+		}
+		if envValue, envValueOk := os.LookupEnv(environmentVariableName(flag.Name)); envValueOk {
+			config.sources[flag.Name] = configSourceEnv
+			applyErr = flag.Value.Set(envValue)
+			return
+		}
+		if projectValue, projectValueOk := projectValues[flag.Name]; projectValueOk {
+			config.sources[flag.Name] = configSourceProject
+			applyErr = setFlagValue(flag, projectValue)
+			return
+		}
+		if userValue, userValueOk := userValues[flag.Name]; userValueOk {
+			config.sources[flag.Name] = configSourceUser
+			applyErr = setFlagValue(flag, userValue)
+			return
+		}
+		config.sources[flag.Name] = configSourceDefault
+	})
+	if applyErr != nil {
+		return nil, applyErr
+	}
+	return config, nil
+}
+
+// WriteConfig writes the effective configuration and the source of each
+// value, redacting secrets
+func (config *LayeredConfig) WriteConfig(writer io.Writer) error {
+	flagNames := make([]string, 0, len(config.sources))
+	for eachName := range config.sources {
+		flagNames = append(flagNames, eachName)
+	}
+	sort.Strings(flagNames)
+
+	tabWriter := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tabWriter, "KEY\tVALUE\tSOURCE\tENVIRONMENT VARIABLE\n")
+	for _, eachName := range flagNames {
+		value := config.flags.Lookup(eachName).Value.String()
+		if secretFlags[eachName] && value != "" {
+			value = "****"
+		}
+		fmt.Fprintf(tabWriter, "%s\t%s\t%s\t%s\n",
+			eachName,
+			value,
+			config.sources[eachName],
+			environmentVariableName(eachName))
+	}
+	return tabWriter.Flush()
+}
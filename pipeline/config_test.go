@@ -0,0 +1,259 @@
+package pipeline
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+// withConfigDirs runs the test in an empty project directory with an empty
+// user configuration directory
+func withConfigDirs(t *testing.T, test func(projectDir string, userConfigDir string)) {
+	tempDir, tempDirErr := ioutil.TempDir("", "config")
+	if tempDirErr != nil {
+		t.Fatal(tempDirErr)
+	}
+	defer os.RemoveAll(tempDir)
+	projectDir := filepath.Join(tempDir, "project")
+	userConfigDir := filepath.Join(tempDir, "xdg", "spartapipeline")
+	for _, eachDir := range []string{projectDir, userConfigDir} {
+		mkdirErr := os.MkdirAll(eachDir, os.ModePerm)
+		if mkdirErr != nil {
+			t.Fatal(mkdirErr)
+		}
+	}
+	workingDir, workingDirErr := os.Getwd()
+	if workingDirErr != nil {
+		t.Fatal(workingDirErr)
+	}
+	chdirErr := os.Chdir(projectDir)
+	if chdirErr != nil {
+		t.Fatal(chdirErr)
+	}
+	defer os.Chdir(workingDir)
+	savedConfigHome, savedConfigHomeOk := os.LookupEnv("XDG_CONFIG_HOME")
+	os.Setenv("XDG_CONFIG_HOME", filepath.Join(tempDir, "xdg"))
+	defer func() {
+		if savedConfigHomeOk {
+			os.Setenv("XDG_CONFIG_HOME", savedConfigHome)
+		} else {
+			os.Unsetenv("XDG_CONFIG_HOME")
+		}
+	}()
+	test(projectDir, userConfigDir)
+}
+
+func TestWriteConfigRedactsSecrets(t *testing.T) {
+	withConfigDirs(t, func(projectDir string, userConfigDir string) {
+		flags := pflag.NewFlagSet("provisionPipeline", pflag.ContinueOnError)
+		flags.String("pipeline", "", "")
+		flags.String("oauth", "", "")
+		flags.String("webhookSecret", "", "")
+		parseErr := flags.Parse([]string{"--pipeline", "MyPipeline",
+			"--oauth", "0123456789abcdef0123456789abcdef01234567",
+			"--webhookSecret", "webhook-signing-secret"})
+		if parseErr != nil {
+			t.Fatal(parseErr)
+		}
+		config, configErr := ApplyLayeredConfig(flags, flags)
+		if configErr != nil {
+			t.Fatal(configErr)
+		}
+		output := &bytes.Buffer{}
+		writeErr := config.WriteConfig(output)
+		if writeErr != nil {
+			t.Fatal(writeErr)
+		}
+		for _, eachSecret := range []string{"0123456789abcdef0123456789abcdef01234567", "webhook-signing-secret"} {
+			if strings.Contains(output.String(), eachSecret) {
+				t.Errorf("Configuration includes the secret %s:\n%s", eachSecret, output.String())
+			}
+		}
+		if strings.Count(output.String(), "****") != 2 {
+			t.Errorf("Configuration doesn't redact the oauth and webhookSecret values:\n%s", output.String())
+		}
+		if !strings.Contains(output.String(), "MyPipeline") {
+			t.Errorf("Configuration doesn't include the pipeline name:\n%s", output.String())
+		}
+	})
+}
+
+func TestEnvironmentVariableName(t *testing.T) {
+	tests := map[string]string{
+		"pipeline":              "SPARTA_PIPELINE_PIPELINE",
+		"s3Bucket":              "SPARTA_PIPELINE_S3_BUCKET",
+		"oauth":                 "SPARTA_PIPELINE_OAUTH",
+		"githubApiURL":          "SPARTA_PIPELINE_GITHUB_API_URL",
+		"webhookSecret":         "SPARTA_PIPELINE_WEBHOOK_SECRET",
+		"terminationProtection": "SPARTA_PIPELINE_TERMINATION_PROTECTION",
+		"vpcID":                 "SPARTA_PIPELINE_VPC_ID",
+		"config-dir":            "SPARTA_PIPELINE_CONFIG_DIR",
+	}
+	for eachFlag, eachExpected := range tests {
+		if envName := environmentVariableName(eachFlag); envName != eachExpected {
+			t.Errorf("Flag %s environment variable %s, expected %s", eachFlag, envName, eachExpected)
+		}
+	}
+}
+
+// testConfigFlags returns a flag set with a string and a repeatable flag
+func testConfigFlags() *pflag.FlagSet {
+	flags := pflag.NewFlagSet("provisionPipeline", pflag.ContinueOnError)
+	flags.String("s3Bucket", "default-bucket", "")
+	flags.StringArray("tag", []string{}, "")
+	return flags
+}
+
+func TestApplyLayeredConfig(t *testing.T) {
+	tests := []struct {
+		name          string
+		userConfig    string
+		projectConfig string
+		env           string
+		args          []string
+		// knownFlags defaults to the test flags
+		knownFlags     *pflag.FlagSet
+		expectedErr    bool
+		expectedBucket string
+		expectedSource string
+		expectedTags   []string
+	}{
+		{
+			name:           "default",
+			expectedBucket: "default-bucket",
+			expectedSource: configSourceDefault,
+			expectedTags:   []string{},
+		},
+		{
+			name:           "user config",
+			userConfig:     "s3Bucket: user-bucket\n",
+			expectedBucket: "user-bucket",
+			expectedSource: configSourceUser,
+			expectedTags:   []string{},
+		},
+		{
+			name:           "project config overrides user config",
+			userConfig:     "s3Bucket: user-bucket\n",
+			projectConfig:  "s3Bucket: project-bucket\n",
+			expectedBucket: "project-bucket",
+			expectedSource: configSourceProject,
+			expectedTags:   []string{},
+		},
+		{
+			name:           "environment overrides config files",
+			userConfig:     "s3Bucket: user-bucket\n",
+			projectConfig:  "s3Bucket: project-bucket\n",
+			env:            "env-bucket",
+			expectedBucket: "env-bucket",
+			expectedSource: configSourceEnv,
+			expectedTags:   []string{},
+		},
+		{
+			name:           "flag overrides everything",
+			userConfig:     "s3Bucket: user-bucket\n",
+			projectConfig:  "s3Bucket: project-bucket\n",
+			env:            "env-bucket",
+			args:           []string{"--s3Bucket", "flag-bucket"},
+			expectedBucket: "flag-bucket",
+			expectedSource: configSourceFlag,
+			expectedTags:   []string{},
+		},
+		{
+			name:           "repeatable list values",
+			projectConfig:  "tag:\n  - team=platform\n  - cost-center=42\n",
+			expectedBucket: "default-bucket",
+			expectedSource: configSourceDefault,
+			expectedTags:   []string{"team=platform", "cost-center=42"},
+		},
+		{
+			name:           "project list replaces the user list",
+			userConfig:     "tag:\n  - team=user\n",
+			projectConfig:  "tag:\n  - team=platform\n",
+			expectedBucket: "default-bucket",
+			expectedSource: configSourceDefault,
+			expectedTags:   []string{"team=platform"},
+		},
+		{
+			name:          "unknown key",
+			projectConfig: "s3Bucket: project-bucket\nbucket: typo\n",
+			expectedErr:   true,
+		},
+		{
+			name:        "unknown user key",
+			userConfig:  "pipelin: typo\n",
+			expectedErr: true,
+		},
+		{
+			name:          "key of another command is ignored",
+			projectConfig: "s3Bucket: project-bucket\nterminationProtection: true\n",
+			knownFlags: func() *pflag.FlagSet {
+				knownFlags := testConfigFlags()
+				knownFlags.Bool("terminationProtection", false, "")
+				return knownFlags
+			}(),
+			expectedBucket: "project-bucket",
+			expectedSource: configSourceProject,
+			expectedTags:   []string{},
+		},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.name, func(t *testing.T) {
+			withConfigDirs(t, func(projectDir string, userConfigDir string) {
+				configFiles := map[string]string{
+					filepath.Join(userConfigDir, "config.yaml"):  eachTest.userConfig,
+					filepath.Join(projectDir, ProjectConfigFile): eachTest.projectConfig,
+				}
+				for eachPath, eachContents := range configFiles {
+					if eachContents == "" {
+						continue
+					}
+					writeErr := ioutil.WriteFile(eachPath, []byte(eachContents), 0644)
+					if writeErr != nil {
+						t.Fatal(writeErr)
+					}
+				}
+				envName := environmentVariableName("s3Bucket")
+				if eachTest.env != "" {
+					os.Setenv(envName, eachTest.env)
+					defer os.Unsetenv(envName)
+				}
+				flags := testConfigFlags()
+				parseErr := flags.Parse(eachTest.args)
+				if parseErr != nil {
+					t.Fatal(parseErr)
+				}
+				knownFlags := eachTest.knownFlags
+				if knownFlags == nil {
+					knownFlags = flags
+				}
+				config, configErr := ApplyLayeredConfig(flags, knownFlags)
+				if eachTest.expectedErr {
+					if configErr == nil {
+						t.Errorf("Expected an error for the configuration files")
+					}
+					return
+				}
+				if configErr != nil {
+					t.Fatal(configErr)
+				}
+				bucket, _ := flags.GetString("s3Bucket")
+				if bucket != eachTest.expectedBucket {
+					t.Errorf("s3Bucket %q, expected %q", bucket, eachTest.expectedBucket)
+				}
+				if config.sources["s3Bucket"] != eachTest.expectedSource {
+					t.Errorf("s3Bucket source %q, expected %q", config.sources["s3Bucket"], eachTest.expectedSource)
+				}
+				tags, _ := flags.GetStringArray("tag")
+				if !reflect.DeepEqual(tags, eachTest.expectedTags) {
+					t.Errorf("Tags %v, expected %v", tags, eachTest.expectedTags)
+				}
+			})
+		})
+	}
+}
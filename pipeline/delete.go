@@ -0,0 +1,268 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/mweagle/Sparta"
+	spartaAWS "github.com/mweagle/Sparta/aws"
+	"github.com/sirupsen/logrus"
+)
+
+// DeleteOptions are the command line options necessary to delete
+// the CloudFormation backed CodeBuild pipeline for this project
+type DeleteOptions struct {
+	Noop         bool
+	PipelineName string `validate:"required"`
+	// Branch selects the ephemeral pipeline of the branch
+	Branch              string
+	IncludeEnvironments bool
+	PurgeBucket         bool
+	// Force disables termination protection on protected stacks before
+	// deleting them
+	Force bool
+}
+
+// stackParameterValue returns the value of the named stack parameter, or
+// the empty string if it isn't defined
+func stackParameterValue(stack *cloudformation.Stack, paramName string) string {
+	for _, eachParam := range stack.Parameters {
+		if aws.StringValue(eachParam.ParameterKey) == paramName {
+			return aws.StringValue(eachParam.ParameterValue)
+		}
+	}
+	return ""
+}
+
+// describeStack returns the stack with the given name or nil if it
+// doesn't exist
+func describeStack(stackName string,
+	awsSession *session.Session) (*cloudformation.Stack, error) {
+	cfSvc := cloudformation.New(awsSession)
+	describeOutput, describeErr := cfSvc.DescribeStacks(&cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackName),
+	})
+	if describeErr != nil {
+		// Missing stacks are reported as a ValidationError
+		awsErr, awsErrOk := describeErr.(awserr.Error)
+		if awsErrOk && strings.Contains(awsErr.Message(), "does not exist") {
+			return nil, nil
+		}
+		return nil, describeErr
+	}
+	if len(describeOutput.Stacks) == 0 {
+		return nil, nil
+	}
+	return describeOutput.Stacks[0], nil
+}
+
+// deleteStack deletes the named stack and waits for the deletion to complete.
+// Stacks with termination protection are only deleted when forced.
+func deleteStack(stackName string,
+	force bool,
+	noop bool,
+	awsSession *session.Session,
+	logger *logrus.Logger) error {
+	stack, stackErr := describeStack(stackName, awsSession)
+	if stackErr != nil {
+		return stackErr
+	}
+	if stack == nil {
+		logger.WithFields(logrus.Fields{
+			"StackName": stackName,
+		}).Info("Stack does not exist")
+		return nil
+	}
+	protected := aws.BoolValue(stack.EnableTerminationProtection)
+	if protected && !force {
+		return fmt.Errorf("Stack %s has termination protection enabled. Use --force to disable it and delete the stack",
+			stackName)
+	}
+	if noop {
+		logger.WithFields(logrus.Fields{
+			"StackName": stackName,
+		}).Info("Bypassing stack deletion due to --noop flag")
+		return nil
+	}
+	if protected {
+		protectErr := setTerminationProtection(stackName, false, awsSession, logger)
+		if protectErr != nil {
+			return protectErr
+		}
+	}
+	logger.WithFields(logrus.Fields{
+		"StackName": stackName,
+	}).Info("Deleting stack")
+
+	cfSvc := cloudformation.New(awsSession)
+	_, deleteErr := cfSvc.DeleteStack(&cloudformation.DeleteStackInput{
+		StackName: stack.StackId,
+	})
+	if deleteErr != nil {
+		return deleteErr
+	}
+	return cfSvc.WaitUntilStackDeleteComplete(&cloudformation.DescribeStacksInput{
+		StackName: stack.StackId,
+	})
+}
+
+// purgeBucket deletes every object version and delete marker in the
+// versioned artifact bucket, then deletes the bucket itself
+func purgeBucket(bucketName string,
+	noop bool,
+	awsSession *session.Session,
+	logger *logrus.Logger) error {
+	if noop {
+		logger.WithFields(logrus.Fields{
+			"Bucket": bucketName,
+		}).Info("Bypassing bucket purge due to --noop flag")
+		return nil
+	}
+	s3Svc := s3.New(awsSession)
+	var deleteErr error
+	listErr := s3Svc.ListObjectVersionsPages(&s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucketName),
+	}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		objects := make([]*s3.ObjectIdentifier, 0)
+		for _, eachVersion := range page.Versions {
+			objects = append(objects, &s3.ObjectIdentifier{
+				Key:       eachVersion.Key,
+				VersionId: eachVersion.VersionId,
+			})
+		}
+		for _, eachMarker := range page.DeleteMarkers {
+			objects = append(objects, &s3.ObjectIdentifier{
+				Key:       eachMarker.Key,
+				VersionId: eachMarker.VersionId,
+			})
+		}
+		if len(objects) == 0 {
+			return true
+		}
+		logger.WithFields(logrus.Fields{
+			"Bucket": bucketName,
+			"Count":  len(objects),
+		}).Info("Deleting object versions")
+		_, deleteErr = s3Svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(bucketName),
+			Delete: &s3.Delete{
+				Objects: objects,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		return deleteErr == nil
+	})
+	if listErr != nil {
+		return listErr
+	}
+	if deleteErr != nil {
+		return deleteErr
+	}
+	logger.WithFields(logrus.Fields{
+		"Bucket": bucketName,
+	}).Info("Deleting bucket")
+	_, deleteBucketErr := s3Svc.DeleteBucket(&s3.DeleteBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+	return deleteBucketErr
+}
+
+// deletePipelineStack deletes the pipeline stack and, optionally, the
+// stacks it deployed and the retained artifact bucket
+func deletePipelineStack(pipelineStack *cloudformation.Stack,
+	includeEnvironments bool,
+	includeBucket bool,
+	force bool,
+	noop bool,
+	awsSession *session.Session,
+	logger *logrus.Logger) error {
+	stackName := aws.StringValue(pipelineStack.StackName)
+
+	// The artifact bucket is retained when the stack is deleted, so look
+	// it up before the stack goes away
+	bucketName := ""
+	if includeBucket {
+		physicalID, physicalIDErr := stackResourceID(stackName,
+			artifactS3BucketResource,
+			awsSession)
+		if physicalIDErr != nil {
+			return physicalIDErr
+		}
+		bucketName = physicalID
+	}
+
+	// The environment stacks are deleted first because CloudFormation
+	// needs the pipeline stack's CloudFormationRole to delete them
+	if includeEnvironments {
+		// Delete the environments in reverse promotion order
+		envStackNames := environmentStackNames(pipelineStack)
+		for eachIndex := len(envStackNames) - 1; eachIndex >= 0; eachIndex-- {
+			envStackName := envStackNames[eachIndex]
+			deleteErr := deleteStack(envStackName,
+				force,
+				noop,
+				awsSession,
+				logger)
+			if deleteErr != nil {
+				return deleteErr
+			}
+		}
+	}
+	deleteErr := deleteStack(stackName,
+		force,
+		noop,
+		awsSession,
+		logger)
+	if deleteErr != nil {
+		return deleteErr
+	}
+	if bucketName != "" {
+		purgeErr := purgeBucket(bucketName,
+			noop,
+			awsSession,
+			logger)
+		if purgeErr != nil {
+			return purgeErr
+		}
+	}
+	logger.WithFields(logrus.Fields{
+		"StackName": stackName,
+	}).Info("Pipeline deleted")
+	return nil
+}
+
+// Delete is responsible for deleting the CloudFormation stack that
+// hosts the CI/CD pipeline and, optionally, the stacks it deployed and
+// the retained artifact bucket
+func Delete(deleteOptions *DeleteOptions) error {
+	logger, loggerErr := sparta.NewLogger("info")
+	if loggerErr != nil {
+		return loggerErr
+	}
+	awsSession := spartaAWS.NewSession(logger)
+	stackName, stackNameErr := newNamingPolicy(deleteOptions.PipelineName,
+		deleteOptions.Branch,
+		deleteOptions.Branch != "").pipelineStackName()
+	if stackNameErr != nil {
+		return stackNameErr
+	}
+	pipelineStack, pipelineStackErr := describeStack(stackName, awsSession)
+	if pipelineStackErr != nil {
+		return pipelineStackErr
+	}
+	if pipelineStack == nil {
+		return fmt.Errorf("Pipeline stack %s does not exist", stackName)
+	}
+	return deletePipelineStack(pipelineStack,
+		deleteOptions.IncludeEnvironments,
+		deleteOptions.PurgeBucket,
+		deleteOptions.Force,
+		deleteOptions.Noop,
+		awsSession,
+		logger)
+}
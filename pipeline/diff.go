@@ -0,0 +1,324 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/mweagle/Sparta"
+	spartaAWS "github.com/mweagle/Sparta/aws"
+)
+
+// driftPollInterval is how often drift detection status is polled
+const driftPollInterval = 3 * time.Second
+
+// redactedKeys are template keys whose values are never printed
+var redactedKeys = map[string]bool{
+	"OAuthToken":       true,
+	"GitHubOAuthToken": true,
+	"WebhookSecret":    true,
+}
+
+// templateChange is a single structural difference between two templates
+type templateChange struct {
+	// Path is the dotted path to the changed value
+	Path string
+	// Kind is one of "+", "-" or "~"
+	Kind     string
+	Deployed interface{}
+	Local    interface{}
+}
+
+// namedElementKey returns the Name of a list element, so that lists of
+// stages and actions are compared by name rather than position
+func namedElementKey(element interface{}) (string, bool) {
+	elementMap, elementMapOk := element.(map[string]interface{})
+	if !elementMapOk {
+		return "", false
+	}
+	name, nameOk := elementMap["Name"].(string)
+	return name, nameOk
+}
+
+// namedElements returns the list elements keyed by Name, or false if
+// any element is unnamed
+func namedElements(elements []interface{}) (map[string]interface{}, bool) {
+	keyed := make(map[string]interface{}, len(elements))
+	for _, eachElement := range elements {
+		name, nameOk := namedElementKey(eachElement)
+		if !nameOk {
+			return nil, false
+		}
+		keyed[name] = eachElement
+	}
+	return keyed, true
+}
+
+func sortedKeys(values ...map[string]interface{}) []string {
+	keySet := make(map[string]bool)
+	for _, eachMap := range values {
+		for eachKey := range eachMap {
+			keySet[eachKey] = true
+		}
+	}
+	keys := make([]string, 0, len(keySet))
+	for eachKey := range keySet {
+		keys = append(keys, eachKey)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// diffMaps compares two keyed values and appends the differences
+func diffMaps(path string,
+	deployed map[string]interface{},
+	local map[string]interface{},
+	changes []templateChange) []templateChange {
+	for _, eachKey := range sortedKeys(deployed, local) {
+		childPath := eachKey
+		if path != "" {
+			childPath = fmt.Sprintf("%s.%s", path, eachKey)
+		}
+		deployedValue, deployedOk := deployed[eachKey]
+		localValue, localOk := local[eachKey]
+		switch {
+		case !deployedOk:
+			changes = append(changes, templateChange{Path: childPath, Kind: "+", Local: localValue})
+		case !localOk:
+			changes = append(changes, templateChange{Path: childPath, Kind: "-", Deployed: deployedValue})
+		default:
+			changes = diffValues(childPath, deployedValue, localValue, changes)
+		}
+	}
+	return changes
+}
+
+// diffValues recursively compares two JSON values and appends the differences
+func diffValues(path string,
+	deployed interface{},
+	local interface{},
+	changes []templateChange) []templateChange {
+	switch deployedTyped := deployed.(type) {
+	case map[string]interface{}:
+		if localTyped, localOk := local.(map[string]interface{}); localOk {
+			return diffMaps(path, deployedTyped, localTyped, changes)
+		}
+	case []interface{}:
+		if localTyped, localOk := local.([]interface{}); localOk {
+			deployedNamed, deployedNamedOk := namedElements(deployedTyped)
+			localNamed, localNamedOk := namedElements(localTyped)
+			if deployedNamedOk && localNamedOk {
+				return diffMaps(path, deployedNamed, localNamed, changes)
+			}
+		}
+	}
+	if !reflect.DeepEqual(deployed, local) {
+		changes = append(changes, templateChange{
+			Path:     path,
+			Kind:     "~",
+			Deployed: deployed,
+			Local:    local,
+		})
+	}
+	return changes
+}
+
+// diffTemplates returns the structural differences between the deployed
+// and locally generated templates
+func diffTemplates(deployed map[string]interface{},
+	local map[string]interface{}) []templateChange {
+	changes := make([]templateChange, 0)
+	for _, eachSection := range []string{"Parameters", "Resources", "Outputs"} {
+		deployedSection, _ := deployed[eachSection].(map[string]interface{})
+		localSection, _ := local[eachSection].(map[string]interface{})
+		if deployedSection == nil {
+			deployedSection = map[string]interface{}{}
+		}
+		if localSection == nil {
+			localSection = map[string]interface{}{}
+		}
+		changes = diffMaps(eachSection, deployedSection, localSection, changes)
+	}
+	return changes
+}
+
+// isRedacted returns true if any component of the dotted path is secret
+func isRedacted(path string) bool {
+	for _, eachPart := range strings.Split(path, ".") {
+		if redactedKeys[eachPart] {
+			return true
+		}
+	}
+	return false
+}
+
+// formatDiffValue returns the compact JSON representation of a template
+// value, redacting secrets
+func formatDiffValue(path string, value interface{}) string {
+	if isRedacted(path) {
+		return "****"
+	}
+	jsonBytes, jsonBytesErr := json.Marshal(value)
+	if jsonBytesErr != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(jsonBytes)
+}
+
+// writeTemplateChanges writes the changes in a diff-like format
+func writeTemplateChanges(changes []templateChange, writer io.Writer) {
+	for _, eachChange := range changes {
+		switch eachChange.Kind {
+		case "+":
+			fmt.Fprintf(writer, "+ %s: %s\n",
+				eachChange.Path,
+				formatDiffValue(eachChange.Path, eachChange.Local))
+		case "-":
+			fmt.Fprintf(writer, "- %s: %s\n",
+				eachChange.Path,
+				formatDiffValue(eachChange.Path, eachChange.Deployed))
+		default:
+			fmt.Fprintf(writer, "~ %s: %s => %s\n",
+				eachChange.Path,
+				formatDiffValue(eachChange.Path, eachChange.Deployed),
+				formatDiffValue(eachChange.Path, eachChange.Local))
+		}
+	}
+}
+
+// writeStackDrift runs CloudFormation drift detection against the deployed
+// stack and writes the resources that were modified outside CloudFormation,
+// eg: by editing the pipeline in the console
+func writeStackDrift(stackName string,
+	cfSvc *cloudformation.CloudFormation,
+	writer io.Writer) error {
+	detectOutput, detectErr := cfSvc.DetectStackDrift(&cloudformation.DetectStackDriftInput{
+		StackName: aws.String(stackName),
+	})
+	if detectErr != nil {
+		return detectErr
+	}
+	for {
+		statusOutput, statusErr := cfSvc.DescribeStackDriftDetectionStatus(&cloudformation.DescribeStackDriftDetectionStatusInput{
+			StackDriftDetectionId: detectOutput.StackDriftDetectionId,
+		})
+		if statusErr != nil {
+			return statusErr
+		}
+		detectionStatus := aws.StringValue(statusOutput.DetectionStatus)
+		if detectionStatus == cloudformation.StackDriftDetectionStatusDetectionFailed {
+			return fmt.Errorf("Drift detection for stack %s failed: %s",
+				stackName,
+				aws.StringValue(statusOutput.DetectionStatusReason))
+		}
+		if detectionStatus != cloudformation.StackDriftDetectionStatusDetectionInProgress {
+			break
+		}
+		time.Sleep(driftPollInterval)
+	}
+	driftsOutput, driftsErr := cfSvc.DescribeStackResourceDrifts(&cloudformation.DescribeStackResourceDriftsInput{
+		StackName: aws.String(stackName),
+		StackResourceDriftStatusFilters: aws.StringSlice([]string{
+			cloudformation.StackResourceDriftStatusModified,
+			cloudformation.StackResourceDriftStatusDeleted,
+		}),
+	})
+	if driftsErr != nil {
+		return driftsErr
+	}
+	if len(driftsOutput.StackResourceDrifts) == 0 {
+		fmt.Fprintf(writer, "No resources in stack %s have drifted\n", stackName)
+		return nil
+	}
+	fmt.Fprintf(writer, "%d resource(s) in stack %s were changed outside CloudFormation\n",
+		len(driftsOutput.StackResourceDrifts),
+		stackName)
+	for _, eachDrift := range driftsOutput.StackResourceDrifts {
+		fmt.Fprintf(writer, "! %s (%s): %s\n",
+			aws.StringValue(eachDrift.LogicalResourceId),
+			aws.StringValue(eachDrift.ResourceType),
+			aws.StringValue(eachDrift.StackResourceDriftStatus))
+		for _, eachDifference := range eachDrift.PropertyDifferences {
+			path := fmt.Sprintf("%s%s",
+				aws.StringValue(eachDrift.LogicalResourceId),
+				strings.Replace(aws.StringValue(eachDifference.PropertyPath), "/", ".", -1))
+			fmt.Fprintf(writer, "  ~ %s: %s => %s\n",
+				path,
+				redactDriftValue(path, aws.StringValue(eachDifference.ExpectedValue)),
+				redactDriftValue(path, aws.StringValue(eachDifference.ActualValue)))
+		}
+	}
+	return nil
+}
+
+func redactDriftValue(path string, value string) string {
+	if isRedacted(path) {
+		return "****"
+	}
+	return value
+}
+
+// Diff is responsible for comparing the locally generated pipeline
+// template with the template of the deployed pipeline stack
+func Diff(provisionOptions *ProvisionOptions, writer io.Writer) error {
+	logger, loggerErr := sparta.NewLogger("info")
+	if loggerErr != nil {
+		return loggerErr
+	}
+	awsSession := spartaAWS.NewSession(logger)
+	cfTemplate, cfTemplateErr := newPipelineTemplate(provisionOptions, awsSession, logger)
+	if cfTemplateErr != nil {
+		return cfTemplateErr
+	}
+	// Resolve the function package keys the same way Provision does
+	packageErr := packageFunctions(cfTemplate, provisionOptions, false, awsSession, logger)
+	if packageErr != nil {
+		return packageErr
+	}
+	// Round trip the local template so both sides have the same JSON types
+	localBytes, localBytesErr := json.Marshal(cfTemplate)
+	if localBytesErr != nil {
+		return localBytesErr
+	}
+	localTemplate := make(map[string]interface{})
+	localErr := json.Unmarshal(localBytes, &localTemplate)
+	if localErr != nil {
+		return localErr
+	}
+
+	stackName, stackNameErr := provisionStackName(provisionOptions)
+	if stackNameErr != nil {
+		return stackNameErr
+	}
+	cfSvc := cloudformation.New(awsSession)
+	templateOutput, templateErr := cfSvc.GetTemplate(&cloudformation.GetTemplateInput{
+		StackName:     aws.String(stackName),
+		TemplateStage: aws.String(cloudformation.TemplateStageOriginal),
+	})
+	if templateErr != nil {
+		return templateErr
+	}
+	deployedTemplate, deployedErr := parseTemplateBody(aws.StringValue(templateOutput.TemplateBody))
+	if deployedErr != nil {
+		return fmt.Errorf("Failed to parse deployed template for stack %s: %s",
+			stackName,
+			deployedErr)
+	}
+	changes := diffTemplates(deployedTemplate, localTemplate)
+	if len(changes) == 0 {
+		fmt.Fprintf(writer, "No differences between the local template and stack %s\n", stackName)
+	} else {
+		fmt.Fprintf(writer, "%d difference(s) between stack %s (-) and the local template (+)\n",
+			len(changes),
+			stackName)
+		writeTemplateChanges(changes, writer)
+	}
+	fmt.Fprintln(writer)
+	return writeStackDrift(stackName, cfSvc, writer)
+}
@@ -0,0 +1,206 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// secretVariableRE matches variable and parameter names whose values are
+// never printed
+var secretVariableRE = regexp.MustCompile(`(?i)(SECRET|PASSWORD|PASSWD|TOKEN|CREDENTIAL|PRIVATE|API_?KEY)`)
+
+// EnvDiffOptions are the options for the envDiff command
+type EnvDiffOptions struct {
+	// Source and Target are the names of the environments to compare
+	Source string `validate:"required"`
+	Target string `validate:"required"`
+	// ConfigDir is the directory with the unzipped template configuration
+	// files generated by provision --codePipelinePackage
+	ConfigDir string `validate:"required"`
+}
+
+// valueChange is a key that differs between two environments
+type valueChange struct {
+	Key string
+	// Kind is one of "+" (only in the target), "-" (only in the source)
+	// or "~"
+	Kind   string
+	Source string
+	Target string
+}
+
+// diffValues compares the source and target values by key
+func diffValues(source map[string]string, target map[string]string) []valueChange {
+	keySet := make(map[string]bool, len(source)+len(target))
+	for eachKey := range source {
+		keySet[eachKey] = true
+	}
+	for eachKey := range target {
+		keySet[eachKey] = true
+	}
+	keys := make([]string, 0, len(keySet))
+	for eachKey := range keySet {
+		keys = append(keys, eachKey)
+	}
+	sort.Strings(keys)
+
+	changes := make([]valueChange, 0)
+	for _, eachKey := range keys {
+		sourceValue, sourceOk := source[eachKey]
+		targetValue, targetOk := target[eachKey]
+		switch {
+		case !sourceOk:
+			changes = append(changes, valueChange{Key: eachKey, Kind: "+", Target: targetValue})
+		case !targetOk:
+			changes = append(changes, valueChange{Key: eachKey, Kind: "-", Source: sourceValue})
+		case sourceValue != targetValue:
+			changes = append(changes, valueChange{eachKey, "~", sourceValue, targetValue})
+		}
+	}
+	return changes
+}
+
+// maskValue returns the value to print for the key. Secret references
+// are printed as is because they don't include the secret.
+func maskValue(key string, value string) string {
+	if secretVariableRE.MatchString(key) && parseSecretReference(value) == nil {
+		return "****"
+	}
+	return value
+}
+
+// writeValueChanges writes the changes in a diff-like format and returns
+// the number of keys that are missing on either side
+func writeValueChanges(title string,
+	options *EnvDiffOptions,
+	changes []valueChange,
+	writer io.Writer) int {
+	fmt.Fprintf(writer, "%s (%s => %s)\n", title, options.Source, options.Target)
+	if len(changes) == 0 {
+		fmt.Fprintf(writer, "  No differences\n")
+	}
+	missingCount := 0
+	for _, eachChange := range changes {
+		switch eachChange.Kind {
+		case "+":
+			missingCount++
+			fmt.Fprintf(writer, "+ %s: %s (missing in %s)\n",
+				eachChange.Key,
+				maskValue(eachChange.Key, eachChange.Target),
+				options.Source)
+		case "-":
+			missingCount++
+			fmt.Fprintf(writer, "- %s: %s (missing in %s)\n",
+				eachChange.Key,
+				maskValue(eachChange.Key, eachChange.Source),
+				options.Target)
+		default:
+			fmt.Fprintf(writer, "~ %s: %s => %s\n",
+				eachChange.Key,
+				maskValue(eachChange.Key, eachChange.Source),
+				maskValue(eachChange.Key, eachChange.Target))
+		}
+	}
+	return missingCount
+}
+
+// registeredEnvironment returns the registered environment with the name
+func registeredEnvironment(environmentName string) (*Environment, error) {
+	for eachIndex := range registeredEnvironments {
+		if registeredEnvironments[eachIndex].Name == environmentName {
+			return &registeredEnvironments[eachIndex], nil
+		}
+	}
+	names := make([]string, 0, len(registeredEnvironments))
+	for _, eachEnvironment := range registeredEnvironments {
+		names = append(names, eachEnvironment.Name)
+	}
+	return nil, fmt.Errorf("Environment %s is not registered (registered: %v)", environmentName, names)
+}
+
+// readTemplateConfigParameters returns the Parameters of the environment's
+// template configuration file, or nil if it hasn't been generated
+func readTemplateConfigParameters(configDir string, environment *Environment) (map[string]string, error) {
+	configFile := environment.ConfigFile
+	if configFile == "" {
+		configFile = fmt.Sprintf("%s.json", environment.Name)
+	}
+	configBytes, configBytesErr := ioutil.ReadFile(filepath.Join(configDir, configFile))
+	if os.IsNotExist(configBytesErr) {
+		return nil, nil
+	}
+	if configBytesErr != nil {
+		return nil, configBytesErr
+	}
+	var templateConfig struct {
+		Parameters map[string]interface{} `json:"Parameters"`
+	}
+	unmarshalErr := json.Unmarshal(configBytes, &templateConfig)
+	if unmarshalErr != nil {
+		return nil, fmt.Errorf("Invalid template configuration file %s: %s", configFile, unmarshalErr)
+	}
+	parameters := make(map[string]string, len(templateConfig.Parameters))
+	for eachKey, eachValue := range templateConfig.Parameters {
+		parameters[eachKey] = fmt.Sprintf("%v", eachValue)
+	}
+	return parameters, nil
+}
+
+// EnvDiff compares the variables of two registered environments and their
+// generated template configuration files. Secret values are masked. It
+// returns an error if a key is missing on either side, so that CI can
+// block incomplete environment definitions.
+func EnvDiff(options *EnvDiffOptions, writer io.Writer) error {
+	source, sourceErr := registeredEnvironment(options.Source)
+	if sourceErr != nil {
+		return sourceErr
+	}
+	target, targetErr := registeredEnvironment(options.Target)
+	if targetErr != nil {
+		return targetErr
+	}
+	missingCount := writeValueChanges("Variables",
+		options,
+		diffValues(source.Variables, target.Variables),
+		writer)
+
+	sourceParameters, sourceParametersErr := readTemplateConfigParameters(options.ConfigDir, source)
+	if sourceParametersErr != nil {
+		return sourceParametersErr
+	}
+	targetParameters, targetParametersErr := readTemplateConfigParameters(options.ConfigDir, target)
+	if targetParametersErr != nil {
+		return targetParametersErr
+	}
+	if sourceParameters == nil && targetParameters == nil {
+		fmt.Fprintf(writer,
+			"Template configuration files not found in %s. Run provision --codePipelinePackage and unzip the package to compare them.\n",
+			options.ConfigDir)
+	} else if sourceParameters == nil || targetParameters == nil {
+		missingName := options.Source
+		if targetParameters == nil {
+			missingName = options.Target
+		}
+		return fmt.Errorf("The %s template configuration file is missing from %s",
+			missingName,
+			options.ConfigDir)
+	} else {
+		missingCount += writeValueChanges("Template configuration parameters",
+			options,
+			diffValues(sourceParameters, targetParameters),
+			writer)
+	}
+	if missingCount != 0 {
+		return fmt.Errorf("Environments %s and %s have %d missing keys",
+			options.Source,
+			options.Target,
+			missingCount)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package pipeline
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   map[string]string
+		target   map[string]string
+		expected []valueChange
+	}{
+		{
+			name:     "equal",
+			source:   map[string]string{"MESSAGE": "Hello!"},
+			target:   map[string]string{"MESSAGE": "Hello!"},
+			expected: []valueChange{},
+		},
+		{
+			name:     "both empty",
+			expected: []valueChange{},
+		},
+		{
+			name:   "missing and changed keys sorted by key",
+			source: map[string]string{"MESSAGE": "Hello Test!", "DEBUG": "true", "ENVIRONMENT": "test"},
+			target: map[string]string{"MESSAGE": "Hello Production!", "ENVIRONMENT": "test", "REGION": "us-west-2"},
+			expected: []valueChange{
+				{Key: "DEBUG", Kind: "-", Source: "true"},
+				{Key: "MESSAGE", Kind: "~", Source: "Hello Test!", Target: "Hello Production!"},
+				{Key: "REGION", Kind: "+", Target: "us-west-2"},
+			},
+		},
+		{
+			name:   "empty value isn't missing",
+			source: map[string]string{"MESSAGE": ""},
+			target: map[string]string{"MESSAGE": "Hello!"},
+			expected: []valueChange{
+				{Key: "MESSAGE", Kind: "~", Target: "Hello!"},
+			},
+		},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.name, func(t *testing.T) {
+			changes := diffValues(eachTest.source, eachTest.target)
+			if !reflect.DeepEqual(changes, eachTest.expected) {
+				t.Errorf("Changes %+v, expected %+v", changes, eachTest.expected)
+			}
+		})
+	}
+}
+
+func TestMaskValue(t *testing.T) {
+	tests := []struct {
+		key      string
+		value    string
+		expected string
+	}{
+		{"MESSAGE", "Hello!", "Hello!"},
+		{"DB_PASSWORD", "hunter2", "****"},
+		{"API_KEY", "abc123", "****"},
+		{"apikey", "abc123", "****"},
+		{"GitHubToken", "abc123", "****"},
+		{"CLIENT_SECRET", "abc123", "****"},
+		{"DB_PASSWORD", "ssm:/prod/db/password", "ssm:/prod/db/password"},
+		{"API_KEY", "secretsmanager:prod/api#key", "secretsmanager:prod/api#key"},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.key+"="+eachTest.value, func(t *testing.T) {
+			if masked := maskValue(eachTest.key, eachTest.value); masked != eachTest.expected {
+				t.Errorf("Masked %q, expected %q", masked, eachTest.expected)
+			}
+		})
+	}
+}
+
+func TestEnvDiff(t *testing.T) {
+	savedEnvironments := registeredEnvironments
+	defer func() {
+		registeredEnvironments = savedEnvironments
+	}()
+	configDir, tempDirErr := ioutil.TempDir("", "envdiff")
+	if tempDirErr != nil {
+		t.Fatal(tempDirErr)
+	}
+	defer os.RemoveAll(configDir)
+	configFiles := map[string]string{
+		"test.json":       `{"Parameters": {"MESSAGE": "Hello Test!"}}`,
+		"production.json": `{"Parameters": {"MESSAGE": "Hello Production!"}}`,
+		"staging.json":    `{"Parameters": {"MESSAGE": "Hello Staging!", "DB_PASSWORD": "hunter2"}}`,
+	}
+	for eachName, eachContents := range configFiles {
+		writeErr := ioutil.WriteFile(filepath.Join(configDir, eachName), []byte(eachContents), 0644)
+		if writeErr != nil {
+			t.Fatal(writeErr)
+		}
+	}
+	registeredEnvironments = []Environment{
+		{Name: "test", Variables: map[string]string{"MESSAGE": "Hello Test!"}},
+		{Name: "production", Variables: map[string]string{"MESSAGE": "Hello Production!"}},
+		{Name: "staging", Variables: map[string]string{"MESSAGE": "Hello Staging!", "DB_PASSWORD": "hunter2"}},
+		{Name: "qa", Variables: map[string]string{"MESSAGE": "Hello Test!"}},
+		{Name: "dev", Variables: map[string]string{"MESSAGE": "Hello Test!"}},
+	}
+	tests := []struct {
+		name   string
+		source string
+		target string
+		// expectedErr is true if a key is missing or an environment isn't
+		// registered
+		expectedErr bool
+		expected    []string
+		unexpected  []string
+	}{
+		{
+			name:     "changed values",
+			source:   "test",
+			target:   "production",
+			expected: []string{"~ MESSAGE: Hello Test! => Hello Production!"},
+		},
+		{
+			name:        "missing secret key is masked",
+			source:      "test",
+			target:      "staging",
+			expectedErr: true,
+			expected:    []string{"+ DB_PASSWORD: **** (missing in test)"},
+			unexpected:  []string{"hunter2"},
+		},
+		{
+			name:        "missing target template configuration file",
+			source:      "test",
+			target:      "qa",
+			expectedErr: true,
+		},
+		{
+			name:        "missing source template configuration file",
+			source:      "qa",
+			target:      "test",
+			expectedErr: true,
+		},
+		{
+			name:     "missing template configuration files",
+			source:   "qa",
+			target:   "dev",
+			expected: []string{"Template configuration files not found"},
+		},
+		{
+			name:        "unregistered environment",
+			source:      "test",
+			target:      "uat",
+			expectedErr: true,
+		},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.name, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			diffErr := EnvDiff(&EnvDiffOptions{
+				Source:    eachTest.source,
+				Target:    eachTest.target,
+				ConfigDir: configDir,
+			}, output)
+			if (diffErr != nil) != eachTest.expectedErr {
+				t.Errorf("EnvDiff error %v, expected an error: %t", diffErr, eachTest.expectedErr)
+			}
+			for _, eachExpected := range eachTest.expected {
+				if !strings.Contains(output.String(), eachExpected) {
+					t.Errorf("Output doesn't include %q:\n%s", eachExpected, output.String())
+				}
+			}
+			for _, eachUnexpected := range eachTest.unexpected {
+				if strings.Contains(output.String(), eachUnexpected) {
+					t.Errorf("Output includes %q:\n%s", eachUnexpected, output.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// EnvironmentsDir is the directory with the environment files
+const EnvironmentsDir = "environments"
+
+// baseEnvironmentName is the name of the environment file that every
+// other environment file inherits from, unless it extends another one.
+// It doesn't define an environment itself.
+const baseEnvironmentName = "base"
+
+// environmentFile is the schema of an environments/<name>.yaml file
+type environmentFile struct {
+	// Order is the promotion order. Files with the same order are sorted
+	// by name.
+	Order int `json:"order"`
+	// Extends is the name of the environment file to inherit from
+	Extends    string            `json:"extends"`
+	StackName  string            `json:"stackName"`
+	ConfigFile string            `json:"configFile"`
+	Approval   string            `json:"approval"`
+	DeployMode string            `json:"deployMode"`
+	Variables  map[string]string `json:"variables"`
+}
+
+// readEnvironmentFile parses the environment file, rejecting keys that
+// aren't part of the schema
+func readEnvironmentFile(environmentPath string) (*environmentFile, error) {
+	yamlBytes, yamlBytesErr := ioutil.ReadFile(environmentPath)
+	if yamlBytesErr != nil {
+		return nil, yamlBytesErr
+	}
+	jsonBytes, jsonBytesErr := yaml.YAMLToJSON(yamlBytes)
+	if jsonBytesErr != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %s", environmentPath, jsonBytesErr)
+	}
+	parsed := &environmentFile{}
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.DisallowUnknownFields()
+	decodeErr := decoder.Decode(parsed)
+	if decodeErr != nil {
+		return nil, fmt.Errorf("Invalid environment file %s: %s", environmentPath, decodeErr)
+	}
+	return parsed, nil
+}
+
+// inherit returns the environment file with the unset values, and
+// variables, of the parent applied
+func (envFile *environmentFile) inherit(parent *environmentFile) *environmentFile {
+	merged := *envFile
+	if merged.StackName == "" {
+		merged.StackName = parent.StackName
+	}
+	if merged.ConfigFile == "" {
+		merged.ConfigFile = parent.ConfigFile
+	}
+	if merged.Approval == "" {
+		merged.Approval = parent.Approval
+	}
+	if merged.DeployMode == "" {
+		merged.DeployMode = parent.DeployMode
+	}
+	merged.Variables = make(map[string]string, len(parent.Variables)+len(envFile.Variables))
+	for eachKey, eachValue := range parent.Variables {
+		merged.Variables[eachKey] = eachValue
+	}
+	for eachKey, eachValue := range envFile.Variables {
+		merged.Variables[eachKey] = eachValue
+	}
+	return &merged
+}
+
+// resolveEnvironmentFile returns the environment file with its ancestors
+// applied
+func resolveEnvironmentFile(environmentName string,
+	envFiles map[string]*environmentFile,
+	visiting map[string]bool) (*environmentFile, error) {
+	envFile := envFiles[environmentName]
+	parentName := envFile.Extends
+	if parentName == "" && environmentName != baseEnvironmentName {
+		if _, hasBase := envFiles[baseEnvironmentName]; hasBase {
+			parentName = baseEnvironmentName
+		}
+	}
+	if parentName == "" {
+		return envFile, nil
+	}
+	if _, parentExists := envFiles[parentName]; !parentExists {
+		return nil, fmt.Errorf("Environment %s extends %s, which doesn't exist", environmentName, parentName)
+	}
+	if visiting[environmentName] {
+		return nil, fmt.Errorf("Environment %s has circular inheritance", environmentName)
+	}
+	visiting[environmentName] = true
+	defer delete(visiting, environmentName)
+	parent, parentErr := resolveEnvironmentFile(parentName, envFiles, visiting)
+	if parentErr != nil {
+		return nil, parentErr
+	}
+	return envFile.inherit(parent), nil
+}
+
+// ReadEnvironments reads the <name>.yaml environment files in the
+// directory, applies inheritance and checks that every environment
+// defines the required variables. The environments are returned in
+// promotion order. It's an error if the directory doesn't define at least
+// one environment.
+func ReadEnvironments(environmentsDir string, requiredKeys ...string) ([]Environment, error) {
+	if _, statErr := os.Stat(environmentsDir); statErr != nil {
+		if os.IsNotExist(statErr) {
+			return nil, fmt.Errorf("Environments directory %s doesn't exist", environmentsDir)
+		}
+		return nil, statErr
+	}
+	environmentPaths, globErr := filepath.Glob(filepath.Join(environmentsDir, "*.yaml"))
+	if globErr != nil {
+		return nil, globErr
+	}
+	envFiles := make(map[string]*environmentFile, len(environmentPaths))
+	for _, eachPath := range environmentPaths {
+		envFile, envFileErr := readEnvironmentFile(eachPath)
+		if envFileErr != nil {
+			return nil, envFileErr
+		}
+		envFiles[strings.TrimSuffix(filepath.Base(eachPath), ".yaml")] = envFile
+	}
+
+	type orderedEnvironment struct {
+		order       int
+		environment Environment
+	}
+	ordered := make([]orderedEnvironment, 0, len(envFiles))
+	validate := newOptionsValidator()
+	for eachName := range envFiles {
+		if eachName == baseEnvironmentName {
+			continue
+		}
+		resolved, resolvedErr := resolveEnvironmentFile(eachName, envFiles, map[string]bool{})
+		if resolvedErr != nil {
+			return nil, resolvedErr
+		}
+		missingKeys := make([]string, 0)
+		for _, eachKey := range requiredKeys {
+			if resolved.Variables[eachKey] == "" {
+				missingKeys = append(missingKeys, eachKey)
+			}
+		}
+		if len(missingKeys) != 0 {
+			return nil, fmt.Errorf("Environment %s is missing required variables: %s",
+				eachName,
+				strings.Join(missingKeys, ", "))
+		}
+		environment := Environment{
+			Name:       eachName,
+			StackName:  resolved.StackName,
+			ConfigFile: resolved.ConfigFile,
+			Approval:   resolved.Approval,
+			DeployMode: resolved.DeployMode,
+			Variables:  resolved.Variables,
+		}
+		validateErr := validate.Struct(&environment)
+		if validateErr != nil {
+			return nil, fmt.Errorf("Invalid environment %s: %s", eachName, validateErr)
+		}
+		ordered = append(ordered, orderedEnvironment{
+			order:       envFiles[eachName].Order,
+			environment: environment,
+		})
+	}
+	if len(ordered) == 0 {
+		return nil, fmt.Errorf("No environments defined in %s", environmentsDir)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].order != ordered[j].order {
+			return ordered[i].order < ordered[j].order
+		}
+		return ordered[i].environment.Name < ordered[j].environment.Name
+	})
+	environments := make([]Environment, len(ordered))
+	for eachIndex, eachEnvironment := range ordered {
+		environments[eachIndex] = eachEnvironment.environment
+	}
+	return environments, nil
+}
+
+// LoadEnvironments reads the environment files in the directory and
+// registers each environment in promotion order
+func LoadEnvironments(environmentsDir string, requiredKeys ...string) error {
+	environments, environmentsErr := ReadEnvironments(environmentsDir, requiredKeys...)
+	if environmentsErr != nil {
+		return environmentsErr
+	}
+	for _, eachEnvironment := range environments {
+		registerErr := RegisterEnvironment(eachEnvironment)
+		if registerErr != nil {
+			return registerErr
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package pipeline
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadEnvironments(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		// expected is nil if ReadEnvironments returns an error
+		expected []Environment
+	}{
+		{
+			name: "inheritance and promotion order",
+			files: map[string]string{
+				"base.yaml": "approval: manual\nvariables:\n  MESSAGE: Hello!\n",
+				"production.yaml": "order: 2\ndeployMode: changeset\n" +
+					"variables:\n  ENVIRONMENT: prod\n",
+				"test.yaml": "order: 1\napproval: none\n" +
+					"variables:\n  MESSAGE: Hello Test!\n  ENVIRONMENT: test\n",
+			},
+			expected: []Environment{
+				{
+					Name:      "test",
+					Approval:  ApprovalNone,
+					Variables: map[string]string{"MESSAGE": "Hello Test!", "ENVIRONMENT": "test"},
+				},
+				{
+					Name:       "production",
+					Approval:   ApprovalManual,
+					DeployMode: DeployChangeSet,
+					Variables:  map[string]string{"MESSAGE": "Hello!", "ENVIRONMENT": "prod"},
+				},
+			},
+		},
+		{
+			name:  "empty directory",
+			files: map[string]string{},
+		},
+		{
+			name: "only the base environment",
+			files: map[string]string{
+				"base.yaml": "variables:\n  MESSAGE: Hello!\n  ENVIRONMENT: base\n",
+			},
+		},
+		{
+			name: "missing required variable",
+			files: map[string]string{
+				"test.yaml": "variables:\n  MESSAGE: Hello Test!\n",
+			},
+		},
+		{
+			name: "unknown key",
+			files: map[string]string{
+				"test.yaml": "approvals: none\nvariables:\n  MESSAGE: Hello Test!\n  ENVIRONMENT: test\n",
+			},
+		},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.name, func(t *testing.T) {
+			environmentsDir, tempDirErr := ioutil.TempDir("", "environments")
+			if tempDirErr != nil {
+				t.Fatal(tempDirErr)
+			}
+			defer os.RemoveAll(environmentsDir)
+			for eachName, eachContents := range eachTest.files {
+				writeErr := ioutil.WriteFile(filepath.Join(environmentsDir, eachName),
+					[]byte(eachContents),
+					0644)
+				if writeErr != nil {
+					t.Fatal(writeErr)
+				}
+			}
+			environments, environmentsErr := ReadEnvironments(environmentsDir,
+				"MESSAGE",
+				"ENVIRONMENT")
+			if eachTest.expected == nil {
+				if environmentsErr == nil {
+					t.Errorf("Environments %+v, expected an error", environments)
+				}
+				return
+			}
+			if environmentsErr != nil {
+				t.Fatal(environmentsErr)
+			}
+			if !reflect.DeepEqual(environments, eachTest.expected) {
+				t.Errorf("Environments %+v, expected %+v", environments, eachTest.expected)
+			}
+		})
+	}
+}
+
+func TestReadEnvironmentsMissingDir(t *testing.T) {
+	environments, environmentsErr := ReadEnvironments(filepath.Join(os.TempDir(), "no-such-environments"))
+	if environmentsErr == nil {
+		t.Errorf("Environments %+v, expected an error for a missing directory", environments)
+	}
+}
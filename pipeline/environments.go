@@ -0,0 +1,478 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/mweagle/Sparta"
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/sirupsen/logrus"
+)
+
+// Environment approval policies
+const (
+	// ApprovalNone deploys without approval
+	ApprovalNone = "none"
+	// ApprovalManual waits for a manual approval
+	ApprovalManual = "manual"
+	// ApprovalAutomated runs the buildspec-verify.yml checks
+	ApprovalAutomated = "automated"
+)
+
+// Environment deploy modes
+const (
+	// DeployDirect creates or updates the stack
+	DeployDirect = "direct"
+	// DeployChangeSet creates a change set, then executes it
+	DeployChangeSet = "changeset"
+)
+
+// environmentsParam is the pipeline stack parameter with the ordered
+// environment names
+const environmentsParam = "Environments"
+
+// verifyProjectResource is the logical name of the CodeBuild project that
+// runs the automated approval checks
+var verifyProjectResource = sparta.CloudFormationResourceName("VerifyProject",
+	"VerifyProject")
+
+// verifyBuildSpec is the buildspec of the automated approval checks
+const verifyBuildSpec = "buildspec-verify.yml"
+
+// verifyImage is the CodeBuild image of the automated approval checks. The
+// checks use the AWS CLI, which the golang build image doesn't include.
+const verifyImage = "aws/codebuild/standard:7.0"
+
+// Environment is a stage of the pipeline that deploys the service stack
+type Environment struct {
+	Name string `validate:"required"`
+	// StackName defaults to a name derived from the pipeline and branch
+	StackName string
+	// ConfigFile is the template configuration file generated by Sparta for
+	// the registered environment, and defaults to <name>.json
+	ConfigFile string
+	// Approval gates the deployment: none, manual or automated
+	Approval string `validate:"omitempty,eq=none|eq=manual|eq=automated"`
+	// DeployMode is either direct or changeset
+	DeployMode string `validate:"omitempty,eq=direct|eq=changeset"`
+	// Variables are the environment variables that Sparta writes to the
+	// template configuration file. Values can reference an SSM parameter,
+	// ssm:/path, or a Secrets Manager secret, secretsmanager:id[#key].
+	Variables map[string]string
+}
+
+// registeredEnvironments are the environments registered by
+// RegisterEnvironment in promotion order
+var registeredEnvironments []Environment
+
+// RegisterEnvironment registers the environment with Sparta, which
+// generates its template configuration file, and appends it to the
+// ordered environments that Provision deploys when there are no
+// --environment options
+func RegisterEnvironment(environment Environment) error {
+	for _, eachKey := range []string{environmentNameVariable, secretKeysVariable} {
+		if _, reserved := environment.Variables[eachKey]; reserved {
+			return fmt.Errorf("Environment %s defines the reserved variable %s",
+				environment.Name,
+				eachKey)
+		}
+	}
+	registerErr := sparta.RegisterCodePipelineEnvironment(environment.Name,
+		environmentVariables(&environment))
+	if registerErr != nil {
+		return registerErr
+	}
+	registeredEnvironments = append(registeredEnvironments, environment)
+	return nil
+}
+
+// legacyEnvironmentTitles are the titles of the environments that existed
+// before environments were configurable, so that their stage, parameter
+// and stack names don't change
+var legacyEnvironmentTitles = map[string]string{
+	"test":       "Test",
+	"production": "Prod",
+}
+
+// environmentTitle returns the name used for the environment's stage and
+// parameters, eg: staging-eu => StagingEu
+func environmentTitle(environmentName string) string {
+	if legacyTitle, legacyTitleOk := legacyEnvironmentTitles[environmentName]; legacyTitleOk {
+		return legacyTitle
+	}
+	titleParts := invalidNameCharsRE.Split(environmentName, -1)
+	for eachIndex, eachPart := range titleParts {
+		if eachPart != "" {
+			titleParts[eachIndex] = strings.ToUpper(eachPart[:1]) + eachPart[1:]
+		}
+	}
+	return strings.Join(titleParts, "")
+}
+
+// environmentStageName returns the pipeline stage name of the environment
+func environmentStageName(environmentName string) string {
+	return environmentTitle(environmentName) + "Stage"
+}
+
+// environmentStackParam returns the parameter with the environment's
+// stack name
+func environmentStackParam(environmentName string) string {
+	return environmentTitle(environmentName) + "StackName"
+}
+
+// environmentConfigParam returns the parameter with the environment's
+// template configuration file name
+func environmentConfigParam(environmentName string) string {
+	return environmentTitle(environmentName) + "StackConfig"
+}
+
+// parseEnvironment parses a name[:approval[:deployMode]] option value. The
+// name must be a registered environment, whose template configuration file
+// the pipeline deploys.
+func parseEnvironment(environmentValue string) (Environment, error) {
+	valueParts := strings.Split(environmentValue, ":")
+	if len(valueParts) > 3 || valueParts[0] == "" {
+		return Environment{}, fmt.Errorf("Invalid environment %s, environments must be in name[:approval[:deployMode]] form",
+			environmentValue)
+	}
+	registered, registeredErr := registeredEnvironment(valueParts[0])
+	if registeredErr != nil {
+		return Environment{}, registeredErr
+	}
+	environment := *registered
+	if len(valueParts) > 1 {
+		environment.Approval = valueParts[1]
+	}
+	if len(valueParts) > 2 {
+		environment.DeployMode = valueParts[2]
+	}
+	return environment, nil
+}
+
+// pipelineEnvironments returns the ordered environments the pipeline
+// deploys, with defaults applied. The --environment options take
+// precedence over the registered environments. By default the first
+// environment is deployed without approval, the others after a manual
+// approval, and the last one, and those with automated approval, via a
+// change set. Ephemeral pipelines only deploy the first environment.
+// Environments of the deployed pipeline stack keep their stack names so
+// that the stacks aren't orphaned.
+func pipelineEnvironments(provisionOptions *ProvisionOptions,
+	names *namingPolicy,
+	deployedStack *cloudformation.Stack,
+	logger *logrus.Logger) ([]Environment, error) {
+	environments := make([]Environment, 0)
+	switch {
+	case len(provisionOptions.Environments) != 0:
+		for _, eachValue := range provisionOptions.Environments {
+			environment, environmentErr := parseEnvironment(eachValue)
+			if environmentErr != nil {
+				return nil, environmentErr
+			}
+			environments = append(environments, environment)
+		}
+	case len(registeredEnvironments) != 0:
+		environments = append(environments, registeredEnvironments...)
+	default:
+		return nil, fmt.Errorf("No environments are registered")
+	}
+	if provisionOptions.Ephemeral {
+		environments = environments[:1]
+		environments[0].Approval = ApprovalNone
+	}
+
+	titles := make(map[string]string, len(environments))
+	for eachIndex := range environments {
+		environment := &environments[eachIndex]
+		title := environmentTitle(environment.Name)
+		if title == "" {
+			return nil, fmt.Errorf("Invalid environment name: %s", environment.Name)
+		}
+		if existing, exists := titles[title]; exists {
+			return nil, fmt.Errorf("Environments %s and %s have the same stage name %s",
+				existing,
+				environment.Name,
+				environmentStageName(environment.Name))
+		}
+		titles[title] = environment.Name
+
+		if environment.StackName == "" {
+			stackName, stackNameErr := names.environmentStackName(environment.Name)
+			if stackNameErr != nil {
+				return nil, stackNameErr
+			}
+			if deployedName := deployedStackName(deployedStack, environment.Name); deployedName != "" {
+				if deployedName != stackName {
+					logger.WithFields(logrus.Fields{
+						"Environment":      environment.Name,
+						"StackName":        deployedName,
+						"DerivedStackName": stackName,
+					}).Warn("Keeping the deployed environment stack name. Set the environment's StackName to rename it.")
+				}
+				stackName = deployedName
+			}
+			environment.StackName = stackName
+		}
+		if environment.ConfigFile == "" {
+			environment.ConfigFile = fmt.Sprintf("%s.json", environment.Name)
+		}
+		if environment.Approval == "" {
+			environment.Approval = ApprovalManual
+			if eachIndex == 0 {
+				environment.Approval = ApprovalNone
+			}
+		}
+		if environment.DeployMode == "" {
+			environment.DeployMode = DeployDirect
+			if environment.Approval == ApprovalAutomated ||
+				(eachIndex != 0 && eachIndex == len(environments)-1) {
+				environment.DeployMode = DeployChangeSet
+			}
+		}
+		switch environment.Approval {
+		case ApprovalNone, ApprovalManual, ApprovalAutomated:
+		default:
+			return nil, fmt.Errorf("Invalid approval policy %s for environment %s (none|manual|automated)",
+				environment.Approval,
+				environment.Name)
+		}
+		switch environment.DeployMode {
+		case DeployDirect, DeployChangeSet:
+		default:
+			return nil, fmt.Errorf("Invalid deploy mode %s for environment %s (direct|changeset)",
+				environment.DeployMode,
+				environment.Name)
+		}
+		// The automated checks verify the change set before it's executed
+		if environment.Approval == ApprovalAutomated && environment.DeployMode != DeployChangeSet {
+			return nil, fmt.Errorf("Environment %s uses automated approval, which requires the changeset deploy mode",
+				environment.Name)
+		}
+	}
+	return environments, nil
+}
+
+// addEnvironmentParameters adds the stack name and configuration file
+// parameters of every environment, and the ordered environment names
+func addEnvironmentParameters(cfTemplate *gocf.Template, environments []Environment) {
+	environmentNames := make([]string, len(environments))
+	for eachIndex, eachEnvironment := range environments {
+		environmentNames[eachIndex] = eachEnvironment.Name
+		cfTemplate.Parameters[environmentStackParam(eachEnvironment.Name)] = &gocf.Parameter{
+			Type: "String",
+			Description: fmt.Sprintf("%s %s service stack",
+				environmentTitle(eachEnvironment.Name),
+				sparta.OptionsGlobal.ServiceName),
+			Default: eachEnvironment.StackName,
+		}
+		cfTemplate.Parameters[environmentConfigParam(eachEnvironment.Name)] = &gocf.Parameter{
+			Type: "String",
+			Description: fmt.Sprintf("The configuration file name for the %s %s stack",
+				environmentTitle(eachEnvironment.Name),
+				sparta.OptionsGlobal.ServiceName),
+			Default: eachEnvironment.ConfigFile,
+		}
+	}
+	cfTemplate.Parameters[environmentsParam] = &gocf.Parameter{
+		Type:        "String",
+		Description: "The pipeline environments in promotion order",
+		Default:     strings.Join(environmentNames, ","),
+	}
+}
+
+// cloudFormationAction returns a CloudFormation deploy action
+func cloudFormationAction(actionName string,
+	runOrder int64,
+	configuration sparta.ArbitraryJSONObject,
+	withTemplate bool) gocf.CodePipelinePipelineActionDeclaration {
+	action := gocf.CodePipelinePipelineActionDeclaration{
+		Name: gocf.String(actionName),
+		ActionTypeID: &gocf.CodePipelinePipelineActionTypeID{
+			Category: gocf.String("Deploy"),
+			Owner:    gocf.String("AWS"),
+			Version:  gocf.String("1"),
+			Provider: gocf.String("CloudFormation"),
+		},
+		Configuration: configuration,
+		RunOrder:      gocf.Integer(runOrder),
+	}
+	if withTemplate {
+		action.InputArtifacts = &gocf.CodePipelinePipelineInputArtifactList{
+			gocf.CodePipelinePipelineInputArtifact{
+				Name: gocf.String("Template"),
+			},
+		}
+	}
+	return action
+}
+
+// approvalAction returns the manual or automated approval action for the
+// environment
+func approvalAction(environment *Environment,
+	runOrder int64,
+	changeSetName string) gocf.CodePipelinePipelineActionDeclaration {
+	if environment.Approval == ApprovalAutomated {
+		return gocf.CodePipelinePipelineActionDeclaration{
+			Name: gocf.String("Verify"),
+			InputArtifacts: &gocf.CodePipelinePipelineInputArtifactList{
+				gocf.CodePipelinePipelineInputArtifact{
+					Name: gocf.String("Source"),
+				},
+			},
+			ActionTypeID: &gocf.CodePipelinePipelineActionTypeID{
+				Category: gocf.String("Test"),
+				Owner:    gocf.String("AWS"),
+				Version:  gocf.String("1"),
+				Provider: gocf.String("CodeBuild"),
+			},
+			Configuration: sparta.ArbitraryJSONObject{
+				"ProjectName": gocf.Ref(verifyProjectResource).String(),
+				"EnvironmentVariables": gocf.Join("",
+					gocf.String(fmt.Sprintf(`[{"name":"ENVIRONMENT","type":"PLAINTEXT","value":"%s"},`,
+						environment.Name)),
+					gocf.String(fmt.Sprintf(`{"name":"CHANGE_SET_NAME","type":"PLAINTEXT","value":"%s"},`,
+						changeSetName)),
+					gocf.String(`{"name":"STACK_NAME","type":"PLAINTEXT","value":"`),
+					gocf.Ref(environmentStackParam(environment.Name)).String(),
+					gocf.String(`"}]`)),
+			},
+			RunOrder: gocf.Integer(runOrder),
+		}
+	}
+	customData := fmt.Sprintf("Would you like to deploy to the %s stack?", environment.Name)
+	if environment.DeployMode == DeployChangeSet {
+		customData = fmt.Sprintf("Would you like to make these %s changes?", environment.Name)
+	}
+	return gocf.CodePipelinePipelineActionDeclaration{
+		Name: gocf.String("Approve"),
+		ActionTypeID: &gocf.CodePipelinePipelineActionTypeID{
+			Category: gocf.String("Approval"),
+			Owner:    gocf.String("AWS"),
+			Version:  gocf.String("1"),
+			Provider: gocf.String("Manual"),
+		},
+		Configuration: sparta.ArbitraryJSONObject{
+			"CustomData": customData,
+		},
+		RunOrder: gocf.Integer(runOrder),
+	}
+}
+
+// environmentStage returns the pipeline stage that deploys the
+// environment. Direct deployments are approved before the stack is
+// updated, change set deployments before the change set is executed.
+func environmentStage(environment *Environment,
+	changeSetName string) gocf.CodePipelinePipelineStageDeclaration {
+	stackName := gocf.Ref(environmentStackParam(environment.Name)).String()
+	roleArn := gocf.GetAtt(cfnRoleResource, "Arn").String()
+	templateConfiguration := gocf.Join("",
+		gocf.String("Template::"),
+		gocf.Ref(environmentConfigParam(environment.Name)).String())
+	templatePath := gocf.Join("",
+		gocf.String("Template::"),
+		gocf.Ref("TemplateFileName").String())
+
+	actions := gocf.CodePipelinePipelineActionDeclarationList{}
+	runOrder := int64(1)
+	if environment.DeployMode == DeployChangeSet {
+		actions = append(actions, cloudFormationAction("CreateChangeSet",
+			runOrder,
+			sparta.ArbitraryJSONObject{
+				"ActionMode":            "CHANGE_SET_REPLACE",
+				"Capabilities":          "CAPABILITY_IAM",
+				"RoleArn":               roleArn,
+				"StackName":             stackName,
+				"ChangeSetName":         changeSetName,
+				"TemplateConfiguration": templateConfiguration,
+				"TemplatePath":          templatePath,
+			},
+			true))
+		runOrder++
+	}
+	if environment.Approval != ApprovalNone {
+		actions = append(actions, approvalAction(environment, runOrder, changeSetName))
+		runOrder++
+	}
+	if environment.DeployMode == DeployChangeSet {
+		actions = append(actions, cloudFormationAction("ExecuteChangeSet",
+			runOrder,
+			sparta.ArbitraryJSONObject{
+				"ActionMode":    "CHANGE_SET_EXECUTE",
+				"ChangeSetName": changeSetName,
+				"RoleArn":       roleArn,
+				"StackName":     stackName,
+			},
+			false))
+	} else {
+		actions = append(actions, cloudFormationAction("CreateStack",
+			runOrder,
+			sparta.ArbitraryJSONObject{
+				"ActionMode":            "CREATE_UPDATE",
+				"Capabilities":          "CAPABILITY_IAM",
+				"RoleArn":               roleArn,
+				"StackName":             stackName,
+				"TemplateConfiguration": templateConfiguration,
+				"TemplatePath":          templatePath,
+			},
+			true))
+	}
+	return gocf.CodePipelinePipelineStageDeclaration{
+		Name:    gocf.String(environmentStageName(environment.Name)),
+		Actions: &actions,
+	}
+}
+
+// hasAutomatedApproval returns true if any environment uses the automated
+// approval checks
+func hasAutomatedApproval(environments []Environment) bool {
+	for _, eachEnvironment := range environments {
+		if eachEnvironment.Approval == ApprovalAutomated {
+			return true
+		}
+	}
+	return false
+}
+
+// deployedStackName returns the environment's stack name in the deployed
+// pipeline stack, or the empty string if the pipeline stack or environment
+// isn't deployed
+func deployedStackName(pipelineStack *cloudformation.Stack, environmentName string) string {
+	if pipelineStack == nil {
+		return ""
+	}
+	return stackParameterValue(pipelineStack, environmentStackParam(environmentName))
+}
+
+// productionStackName returns the pipeline stack's stack name for the
+// production environment, which is the last registered environment in
+// promotion order. It returns the empty string if the pipeline doesn't
+// deploy the production environment, eg: an ephemeral pipeline or an
+// --environment list without it.
+func productionStackName(pipelineStack *cloudformation.Stack) string {
+	if len(registeredEnvironments) == 0 {
+		return ""
+	}
+	return deployedStackName(pipelineStack,
+		registeredEnvironments[len(registeredEnvironments)-1].Name)
+}
+
+// environmentStackNames returns the stack names of the pipeline stack's
+// environments in promotion order. Stacks provisioned before environments
+// were configurable have fixed Test and Prod parameters.
+func environmentStackNames(pipelineStack *cloudformation.Stack) []string {
+	paramNames := []string{"TestStackName", "ProdStackName"}
+	if environmentNames := stackParameterValue(pipelineStack, environmentsParam); environmentNames != "" {
+		paramNames = paramNames[:0]
+		for _, eachName := range strings.Split(environmentNames, ",") {
+			paramNames = append(paramNames, environmentStackParam(eachName))
+		}
+	}
+	stackNames := make([]string, 0, len(paramNames))
+	for _, eachParamName := range paramNames {
+		if stackName := stackParameterValue(pipelineStack, eachParamName); stackName != "" {
+			stackNames = append(stackNames, stackName)
+		}
+	}
+	return stackNames
+}
@@ -0,0 +1,207 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/sirupsen/logrus"
+)
+
+func testStack(parameters map[string]string) *cloudformation.Stack {
+	stack := &cloudformation.Stack{}
+	for eachKey, eachValue := range parameters {
+		stack.Parameters = append(stack.Parameters, &cloudformation.Parameter{
+			ParameterKey:   aws.String(eachKey),
+			ParameterValue: aws.String(eachValue),
+		})
+	}
+	return stack
+}
+
+func TestDeployedStackName(t *testing.T) {
+	legacyStack := testStack(map[string]string{
+		"TestStackName": "Test-MyService-master",
+		"ProdStackName": "Prod-MyService-master",
+	})
+	environmentsStack := testStack(map[string]string{
+		environmentsParam:                "test,staging",
+		environmentStackParam("test"):    "Test-MyService-SpartaPipeline-master",
+		environmentStackParam("staging"): "Staging-MyService-SpartaPipeline-master",
+	})
+	tests := []struct {
+		name        string
+		stack       *cloudformation.Stack
+		environment string
+		expected    string
+	}{
+		{"not deployed", nil, "test", ""},
+		{"legacy test", legacyStack, "test", "Test-MyService-master"},
+		{"legacy production", legacyStack, "production", "Prod-MyService-master"},
+		{"legacy new environment", legacyStack, "staging", ""},
+		{"environment", environmentsStack, "staging", "Staging-MyService-SpartaPipeline-master"},
+		{"undeployed environment", environmentsStack, "production", ""},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.name, func(t *testing.T) {
+			stackName := deployedStackName(eachTest.stack, eachTest.environment)
+			if stackName != eachTest.expected {
+				t.Errorf("Stack name %q, expected %q", stackName, eachTest.expected)
+			}
+		})
+	}
+}
+
+func TestEnvironmentTitle(t *testing.T) {
+	tests := map[string]string{
+		"test":       "Test",
+		"production": "Prod",
+		"staging":    "Staging",
+		"staging-eu": "StagingEu",
+	}
+	for eachName, eachExpected := range tests {
+		if title := environmentTitle(eachName); title != eachExpected {
+			t.Errorf("Environment %s title %q, expected %q", eachName, title, eachExpected)
+		}
+	}
+}
+
+func TestPipelineEnvironments(t *testing.T) {
+	savedEnvironments := registeredEnvironments
+	defer func() {
+		registeredEnvironments = savedEnvironments
+	}()
+	registeredEnvironments = []Environment{
+		{Name: "test"},
+		{Name: "staging", StackName: "Staging-MyService"},
+		{Name: "production"},
+	}
+	names := &namingPolicy{
+		serviceName:  "MyService",
+		pipelineName: "SpartaPipeline",
+		branch:       "master",
+	}
+	tests := []struct {
+		name         string
+		environments []string
+		expected     []Environment
+	}{
+		{
+			name: "registered environments",
+			expected: []Environment{
+				{Name: "test", StackName: "Test-MyService-SpartaPipeline-master", Approval: ApprovalNone, DeployMode: DeployDirect},
+				{Name: "staging", StackName: "Staging-MyService", Approval: ApprovalManual, DeployMode: DeployDirect},
+				{Name: "production", StackName: "Prod-MyService-SpartaPipeline-master", Approval: ApprovalManual, DeployMode: DeployChangeSet},
+			},
+		},
+		{
+			name:         "environment options",
+			environments: []string{"test", "staging:automated"},
+			expected: []Environment{
+				{Name: "test", StackName: "Test-MyService-SpartaPipeline-master", Approval: ApprovalNone, DeployMode: DeployDirect},
+				{Name: "staging", StackName: "Staging-MyService", Approval: ApprovalAutomated, DeployMode: DeployChangeSet},
+			},
+		},
+		{
+			name:         "unregistered environment",
+			environments: []string{"test", "qa"},
+		},
+		{
+			name:         "automated approval with direct deploy mode",
+			environments: []string{"test", "production:automated:direct"},
+		},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.name, func(t *testing.T) {
+			environments, environmentsErr := pipelineEnvironments(&ProvisionOptions{Environments: eachTest.environments},
+				names,
+				nil,
+				logrus.New())
+			if eachTest.expected == nil {
+				if environmentsErr == nil {
+					t.Errorf("Environments %+v, expected an error", environments)
+				}
+				return
+			}
+			if environmentsErr != nil {
+				t.Fatal(environmentsErr)
+			}
+			if len(environments) != len(eachTest.expected) {
+				t.Fatalf("Environments %+v, expected %+v", environments, eachTest.expected)
+			}
+			for eachIndex, eachEnvironment := range environments {
+				expected := eachTest.expected[eachIndex]
+				expected.ConfigFile = expected.Name + ".json"
+				if !reflect.DeepEqual(eachEnvironment, expected) {
+					t.Errorf("Environment %+v, expected %+v", eachEnvironment, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestPipelineEnvironmentsUnregistered(t *testing.T) {
+	savedEnvironments := registeredEnvironments
+	defer func() {
+		registeredEnvironments = savedEnvironments
+	}()
+	registeredEnvironments = nil
+	environments, environmentsErr := pipelineEnvironments(&ProvisionOptions{},
+		&namingPolicy{serviceName: "MyService", pipelineName: "SpartaPipeline", branch: "master"},
+		nil,
+		logrus.New())
+	if environmentsErr == nil {
+		t.Errorf("Environments %+v, expected an error when no environments are registered", environments)
+	}
+}
+
+func TestProductionStackName(t *testing.T) {
+	savedEnvironments := registeredEnvironments
+	defer func() {
+		registeredEnvironments = savedEnvironments
+	}()
+	registeredEnvironments = []Environment{
+		{Name: "test"},
+		{Name: "staging"},
+		{Name: "production"},
+	}
+	tests := []struct {
+		name     string
+		stack    *cloudformation.Stack
+		expected string
+	}{
+		{
+			name: "pipeline deploys production",
+			stack: testStack(map[string]string{
+				environmentsParam:                   "test,staging,production",
+				environmentStackParam("test"):       "Test-MyService",
+				environmentStackParam("staging"):    "Staging-MyService",
+				environmentStackParam("production"): "Prod-MyService",
+			}),
+			expected: "Prod-MyService",
+		},
+		{
+			name: "ephemeral pipeline only deploys the first environment",
+			stack: testStack(map[string]string{
+				environmentsParam:             "test",
+				environmentStackParam("test"): "Test-MyService-feature",
+			}),
+		},
+		{
+			name: "environment list without production",
+			stack: testStack(map[string]string{
+				environmentsParam:                "test,staging",
+				environmentStackParam("test"):    "Test-MyService",
+				environmentStackParam("staging"): "Staging-MyService",
+			}),
+		},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.name, func(t *testing.T) {
+			if stackName := productionStackName(eachTest.stack); stackName != eachTest.expected {
+				t.Errorf("Production stack name %q, expected %q", stackName, eachTest.expected)
+			}
+		})
+	}
+}
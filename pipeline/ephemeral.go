@@ -0,0 +1,219 @@
+package pipeline
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/mweagle/Sparta"
+	spartaAWS "github.com/mweagle/Sparta/aws"
+	"github.com/sirupsen/logrus"
+)
+
+// Tags that identify ephemeral pipeline stacks and the branch they build
+const (
+	ephemeralTagKey = "sparta:pipeline:ephemeral"
+	branchTagKey    = "sparta:pipeline:branch"
+)
+
+// githubAPIURL is the default GitHub REST API endpoint
+const githubAPIURL = "https://api.github.com"
+
+// GCOptions are the command line options necessary to delete expired
+// ephemeral pipelines
+type GCOptions struct {
+	Noop             bool
+	TTL              time.Duration `validate:"min=0"`
+	GithubOAuthToken string        `validate:"omitempty,githubtoken"`
+	PurgeBucket      bool
+}
+
+// pipelineSource returns the GitHub repository and branch the pipeline
+// builds. The --branch option takes precedence over the repository URL.
+func pipelineSource(provisionOptions *ProvisionOptions) (*gitHubRepo, error) {
+	source, sourceErr := parseGitHubRepo(provisionOptions.GithubRepo)
+	if sourceErr != nil {
+		return nil, sourceErr
+	}
+	if provisionOptions.Branch != "" {
+		source.Branch = provisionOptions.Branch
+	}
+	return source, nil
+}
+
+// addEphemeralTags adds the tags that GCPipelines uses to find the
+// ephemeral pipeline stack
+func addEphemeralTags(stackTags map[string]string,
+	provisionOptions *ProvisionOptions) error {
+	source, sourceErr := pipelineSource(provisionOptions)
+	if sourceErr != nil {
+		return sourceErr
+	}
+	stackTags[ephemeralTagKey] = "true"
+	stackTags[branchTagKey] = source.Branch
+	return nil
+}
+
+// stackTagValue returns the value of the named stack tag, or the empty
+// string if it isn't defined
+func stackTagValue(stack *cloudformation.Stack, tagKey string) string {
+	for _, eachTag := range stack.Tags {
+		if aws.StringValue(eachTag.Key) == tagKey {
+			return aws.StringValue(eachTag.Value)
+		}
+	}
+	return ""
+}
+
+// githubStatusCode returns the HTTP status code of a GitHub API GET request
+func githubStatusCode(requestURL string, oauthToken string) (int, string, error) {
+	request, requestErr := http.NewRequest(http.MethodGet, requestURL, nil)
+	if requestErr != nil {
+		return 0, "", requestErr
+	}
+	request.Header.Set("Accept", "application/vnd.github.v3+json")
+	if oauthToken != "" {
+		request.Header.Set("Authorization", "token "+oauthToken)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, responseErr := client.Do(request)
+	if responseErr != nil {
+		return 0, "", responseErr
+	}
+	defer response.Body.Close()
+	return response.StatusCode, response.Status, nil
+}
+
+// branchExists returns true if the GitHub repository has the branch. GitHub
+// also returns 404 for a private repository that the token can't read, so
+// the repository lookup must succeed before a missing branch is trusted.
+func branchExists(baseURL string,
+	owner string,
+	repo string,
+	branch string,
+	oauthToken string) (bool, error) {
+	repoURL := fmt.Sprintf("%s/repos/%s/%s",
+		baseURL,
+		url.PathEscape(owner),
+		url.PathEscape(repo))
+	repoStatusCode, repoStatus, repoErr := githubStatusCode(repoURL, oauthToken)
+	if repoErr != nil {
+		return false, repoErr
+	}
+	if repoStatusCode != http.StatusOK {
+		return false, fmt.Errorf("Failed to read the GitHub repository %s/%s (%s). "+
+			"Private repositories require a --oauth token that can read them",
+			owner,
+			repo,
+			repoStatus)
+	}
+	branchStatusCode, branchStatus, branchErr := githubStatusCode(repoURL+"/branches/"+url.PathEscape(branch),
+		oauthToken)
+	if branchErr != nil {
+		return false, branchErr
+	}
+	switch branchStatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("Failed to check branch %s of %s/%s: %s",
+			branch,
+			owner,
+			repo,
+			branchStatus)
+	}
+}
+
+// expiredReason returns why the ephemeral pipeline stack should be
+// deleted, or the empty string if it should be kept
+func expiredReason(stack *cloudformation.Stack, gcOptions *GCOptions) (string, error) {
+	if gcOptions.TTL > 0 {
+		age := time.Since(aws.TimeValue(stack.CreationTime))
+		if age > gcOptions.TTL {
+			return fmt.Sprintf("Created %s ago, which exceeds the %s TTL",
+				age.Round(time.Minute),
+				gcOptions.TTL), nil
+		}
+	}
+	branch := stackTagValue(stack, branchTagKey)
+	exists, existsErr := branchExists(githubBaseURL(),
+		stackParameterValue(stack, "GitHubUser"),
+		stackParameterValue(stack, "GitHubRepoName"),
+		branch,
+		gcOptions.GithubOAuthToken)
+	if existsErr != nil {
+		return "", existsErr
+	}
+	if !exists {
+		return fmt.Sprintf("Branch %s no longer exists", branch), nil
+	}
+	return "", nil
+}
+
+// GCPipelines is responsible for deleting the ephemeral pipelines, and
+// their test stacks, whose branch no longer exists or that are older
+// than the TTL
+func GCPipelines(gcOptions *GCOptions) error {
+	logger, loggerErr := sparta.NewLogger("info")
+	if loggerErr != nil {
+		return loggerErr
+	}
+	awsSession := spartaAWS.NewSession(logger)
+	cfSvc := cloudformation.New(awsSession)
+
+	stackPrefix := pipelineStackName("")
+	ephemeralStacks := make([]*cloudformation.Stack, 0)
+	describeErr := cfSvc.DescribeStacksPages(&cloudformation.DescribeStacksInput{},
+		func(page *cloudformation.DescribeStacksOutput, lastPage bool) bool {
+			for _, eachStack := range page.Stacks {
+				if strings.HasPrefix(aws.StringValue(eachStack.StackName), stackPrefix) &&
+					aws.StringValue(eachStack.StackStatus) != cloudformation.StackStatusDeleteInProgress &&
+					stackTagValue(eachStack, ephemeralTagKey) == "true" {
+					ephemeralStacks = append(ephemeralStacks, eachStack)
+				}
+			}
+			return true
+		})
+	if describeErr != nil {
+		return describeErr
+	}
+	logger.WithFields(logrus.Fields{
+		"Count": len(ephemeralStacks),
+	}).Info("Ephemeral pipelines")
+
+	for _, eachStack := range ephemeralStacks {
+		stackName := aws.StringValue(eachStack.StackName)
+		reason, reasonErr := expiredReason(eachStack, gcOptions)
+		if reasonErr != nil {
+			return reasonErr
+		}
+		if reason == "" {
+			logger.WithFields(logrus.Fields{
+				"StackName": stackName,
+				"Branch":    stackTagValue(eachStack, branchTagKey),
+			}).Info("Keeping ephemeral pipeline")
+			continue
+		}
+		logger.WithFields(logrus.Fields{
+			"StackName": stackName,
+			"Reason":    reason,
+		}).Info("Deleting ephemeral pipeline")
+		deleteErr := deletePipelineStack(eachStack,
+			true,
+			gcOptions.PurgeBucket,
+			false,
+			gcOptions.Noop,
+			awsSession,
+			logger)
+		if deleteErr != nil {
+			return deleteErr
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package pipeline
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBranchExists(t *testing.T) {
+	tests := []struct {
+		name             string
+		repoStatusCode   int
+		branchStatusCode int
+		// expectedErr is true if the branch can't be checked
+		expectedErr bool
+		expected    bool
+	}{
+		{"branch", http.StatusOK, http.StatusOK, false, true},
+		{"deleted branch", http.StatusOK, http.StatusNotFound, false, false},
+		{"private repository", http.StatusNotFound, http.StatusNotFound, true, false},
+		{"bad credentials", http.StatusUnauthorized, http.StatusNotFound, true, false},
+		{"forbidden", http.StatusForbidden, http.StatusNotFound, true, false},
+		{"branch lookup failure", http.StatusOK, http.StatusInternalServerError, true, false},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.name, func(t *testing.T) {
+			var authorization string
+			server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				authorization = request.Header.Get("Authorization")
+				switch request.URL.Path {
+				case "/repos/owner/repo":
+					writer.WriteHeader(eachTest.repoStatusCode)
+				case "/repos/owner/repo/branches/develop":
+					writer.WriteHeader(eachTest.branchStatusCode)
+				default:
+					t.Errorf("Unexpected request path %s", request.URL.Path)
+					writer.WriteHeader(http.StatusBadRequest)
+				}
+			}))
+			defer server.Close()
+
+			exists, existsErr := branchExists(server.URL,
+				"owner",
+				"repo",
+				"develop",
+				"test-token")
+			if (existsErr != nil) != eachTest.expectedErr {
+				t.Fatalf("Branch error %v, expected an error: %t", existsErr, eachTest.expectedErr)
+			}
+			if exists != eachTest.expected {
+				t.Errorf("Branch exists %t, expected %t", exists, eachTest.expected)
+			}
+			if authorization != "token test-token" {
+				t.Errorf("Authorization %q, expected %q", authorization, "token test-token")
+			}
+		})
+	}
+}
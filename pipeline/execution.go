@@ -0,0 +1,203 @@
+package pipeline
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codepipeline"
+	"github.com/aws/aws-sdk-go/service/codepipeline/codepipelineiface"
+	"github.com/mweagle/Sparta"
+	spartaAWS "github.com/mweagle/Sparta/aws"
+	"github.com/sirupsen/logrus"
+)
+
+// ReleaseOptions are the command line options necessary to start a
+// pipeline execution
+type ReleaseOptions struct {
+	Noop         bool
+	PipelineName string `validate:"required"`
+}
+
+// StopOptions are the command line options necessary to stop the
+// current pipeline execution
+type StopOptions struct {
+	Noop         bool
+	PipelineName string `validate:"required"`
+	Abandon      bool
+	Reason       string
+}
+
+// RetryOptions are the command line options necessary to retry the
+// failed actions in a pipeline stage
+type RetryOptions struct {
+	Noop         bool
+	PipelineName string `validate:"required"`
+	StageName    string `validate:"required"`
+}
+
+// pipelineClient returns the CodePipeline client and the resolved
+// CodePipeline name for the named pipeline
+func pipelineClient(pipelineName string,
+	logger *logrus.Logger) (codepipelineiface.CodePipelineAPI, string, error) {
+	awsSession := spartaAWS.NewSession(logger)
+	codePipelineName, codePipelineNameErr := resolvePipelineName(pipelineName,
+		awsSession)
+	if codePipelineNameErr != nil {
+		return nil, "", codePipelineNameErr
+	}
+	return codepipeline.New(awsSession), codePipelineName, nil
+}
+
+// inProgressExecutionID returns the ID of the in progress execution
+func inProgressExecutionID(client codepipelineiface.CodePipelineAPI,
+	codePipelineName string) (string, error) {
+	executionID := ""
+	listErr := client.ListPipelineExecutionsPages(&codepipeline.ListPipelineExecutionsInput{
+		PipelineName: aws.String(codePipelineName),
+	}, func(page *codepipeline.ListPipelineExecutionsOutput, lastPage bool) bool {
+		for _, eachSummary := range page.PipelineExecutionSummaries {
+			if aws.StringValue(eachSummary.Status) == codepipeline.PipelineExecutionStatusInProgress {
+				executionID = aws.StringValue(eachSummary.PipelineExecutionId)
+				return false
+			}
+		}
+		return true
+	})
+	if listErr != nil {
+		return "", listErr
+	}
+	if executionID == "" {
+		return "", fmt.Errorf("Pipeline %s has no execution in progress", codePipelineName)
+	}
+	return executionID, nil
+}
+
+// Release is responsible for starting a new execution of the provisioned
+// CI/CD pipeline. The execution releases the latest commit on the pipeline
+// branch: the version 1 GitHub (ThirdParty) source action that Provision
+// creates doesn't support source revision overrides, so a specific commit
+// can't be released.
+func Release(releaseOptions *ReleaseOptions) error {
+	logger, loggerErr := sparta.NewLogger("info")
+	if loggerErr != nil {
+		return loggerErr
+	}
+	client, codePipelineName, clientErr := pipelineClient(releaseOptions.PipelineName, logger)
+	if clientErr != nil {
+		return clientErr
+	}
+	logFields := logrus.Fields{
+		"Pipeline": codePipelineName,
+	}
+	if releaseOptions.Noop {
+		logger.WithFields(logFields).Info("Bypassing pipeline release due to --noop flag")
+		return nil
+	}
+	startOutput, startErr := client.StartPipelineExecution(&codepipeline.StartPipelineExecutionInput{
+		Name: aws.String(codePipelineName),
+	})
+	if startErr != nil {
+		return startErr
+	}
+	logFields["ExecutionID"] = aws.StringValue(startOutput.PipelineExecutionId)
+	logger.WithFields(logFields).Info("Pipeline execution started")
+	return nil
+}
+
+// Stop is responsible for stopping, or abandoning, the in progress
+// execution of the provisioned CI/CD pipeline
+func Stop(stopOptions *StopOptions) error {
+	logger, loggerErr := sparta.NewLogger("info")
+	if loggerErr != nil {
+		return loggerErr
+	}
+	client, codePipelineName, clientErr := pipelineClient(stopOptions.PipelineName, logger)
+	if clientErr != nil {
+		return clientErr
+	}
+	executionID, executionIDErr := inProgressExecutionID(client, codePipelineName)
+	if executionIDErr != nil {
+		return executionIDErr
+	}
+	logFields := logrus.Fields{
+		"Pipeline":    codePipelineName,
+		"ExecutionID": executionID,
+		"Abandon":     stopOptions.Abandon,
+	}
+	if stopOptions.Noop {
+		logger.WithFields(logFields).Info("Bypassing pipeline stop due to --noop flag")
+		return nil
+	}
+	reason := stopOptions.Reason
+	if reason == "" {
+		reason = fmt.Sprintf("Stopped from the %s command line",
+			sparta.OptionsGlobal.ServiceName)
+	}
+	_, stopErr := client.StopPipelineExecution(&codepipeline.StopPipelineExecutionInput{
+		PipelineName:        aws.String(codePipelineName),
+		PipelineExecutionId: aws.String(executionID),
+		Abandon:             aws.Bool(stopOptions.Abandon),
+		Reason:              aws.String(reason),
+	})
+	if stopErr != nil {
+		return stopErr
+	}
+	logger.WithFields(logFields).Info("Pipeline execution stopping")
+	return nil
+}
+
+// RetryStage is responsible for retrying the failed actions in a stage
+// of the provisioned CI/CD pipeline
+func RetryStage(retryOptions *RetryOptions) error {
+	logger, loggerErr := sparta.NewLogger("info")
+	if loggerErr != nil {
+		return loggerErr
+	}
+	client, codePipelineName, clientErr := pipelineClient(retryOptions.PipelineName, logger)
+	if clientErr != nil {
+		return clientErr
+	}
+	stateOutput, stateErr := client.GetPipelineState(&codepipeline.GetPipelineStateInput{
+		Name: aws.String(codePipelineName),
+	})
+	if stateErr != nil {
+		return stateErr
+	}
+	var stageExecution *codepipeline.StageExecution
+	for _, eachStage := range stateOutput.StageStates {
+		if aws.StringValue(eachStage.StageName) == retryOptions.StageName {
+			stageExecution = eachStage.LatestExecution
+		}
+	}
+	if stageExecution == nil {
+		return fmt.Errorf("Stage %s in pipeline %s has not been executed",
+			retryOptions.StageName,
+			codePipelineName)
+	}
+	if aws.StringValue(stageExecution.Status) != codepipeline.StageExecutionStatusFailed {
+		return fmt.Errorf("Stage %s in pipeline %s is %s, only failed stages can be retried",
+			retryOptions.StageName,
+			codePipelineName,
+			aws.StringValue(stageExecution.Status))
+	}
+	logFields := logrus.Fields{
+		"Pipeline":    codePipelineName,
+		"Stage":       retryOptions.StageName,
+		"ExecutionID": aws.StringValue(stageExecution.PipelineExecutionId),
+	}
+	if retryOptions.Noop {
+		logger.WithFields(logFields).Info("Bypassing stage retry due to --noop flag")
+		return nil
+	}
+	_, retryErr := client.RetryStageExecution(&codepipeline.RetryStageExecutionInput{
+		PipelineName:        aws.String(codePipelineName),
+		StageName:           aws.String(retryOptions.StageName),
+		PipelineExecutionId: stageExecution.PipelineExecutionId,
+		RetryMode:           aws.String(codepipeline.StageRetryModeFailedActions),
+	})
+	if retryErr != nil {
+		return retryErr
+	}
+	logger.WithFields(logFields).Info("Stage retry started")
+	return nil
+}
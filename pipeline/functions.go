@@ -0,0 +1,197 @@
+package pipeline
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/mweagle/Sparta"
+	spartaS3 "github.com/mweagle/Sparta/aws/s3"
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/sirupsen/logrus"
+)
+
+// AssumePolicyLambdaRoleDocument is the AssumeRole document for the
+// roles of the Lambda functions provisioned with the pipeline
+var AssumePolicyLambdaRoleDocument = sparta.ArbitraryJSONObject{
+	"Version": "2012-10-17",
+	"Statement": []sparta.ArbitraryJSONObject{
+		{
+			"Effect": "Allow",
+			"Principal": sparta.ArbitraryJSONObject{
+				"Service": []string{"lambda.amazonaws.com"},
+			},
+			"Action": []string{"sts:AssumeRole"},
+		},
+	},
+}
+
+// Pipeline functions run on the OS-only runtime, which executes the
+// bootstrap binary in the package
+const (
+	lambdaRuntime   = "provided.al2"
+	lambdaBootstrap = "bootstrap"
+)
+
+// pipelineFunction is a Go Lambda function that Provision builds and
+// deploys in the pipeline stack
+type pipelineFunction struct {
+	// handlerName identifies the function in package keys and logs
+	handlerName string
+	// importPath is the import path of the main package
+	importPath string
+	// codeKeyParam is the template parameter with the package S3 key
+	codeKeyParam string
+}
+
+// codeKey returns the S3 key of the function package. The package hash is
+// part of the key so that CloudFormation updates the function when the
+// code changes.
+func (function *pipelineFunction) codeKey(pipelineName string, packageHash string) string {
+	return fmt.Sprintf("%s-%s-%s.zip",
+		pipelineStackName(pipelineName),
+		function.handlerName,
+		packageHash)
+}
+
+// addCodeKeyParameter adds the package S3 key parameter. packageFunctions
+// replaces the placeholder default with the key of the built package.
+func (function *pipelineFunction) addCodeKeyParameter(cfTemplate *gocf.Template,
+	pipelineName string) {
+	cfTemplate.Parameters[function.codeKeyParam] = &gocf.Parameter{
+		Type:        "String",
+		Description: fmt.Sprintf("S3 key of the %s function package", function.handlerName),
+		Default:     function.codeKey(pipelineName, "latest"),
+	}
+}
+
+// code returns the function Code property
+func (function *pipelineFunction) code(s3Bucket string) *gocf.LambdaFunctionCode {
+	return &gocf.LambdaFunctionCode{
+		S3Bucket: gocf.String(s3Bucket),
+		S3Key:    gocf.Ref(function.codeKeyParam).String(),
+	}
+}
+
+// pipelineFunctions returns the functions the options provision
+func pipelineFunctions(provisionOptions *ProvisionOptions) []*pipelineFunction {
+	functions := make([]*pipelineFunction, 0)
+	if provisionOptions.CommitStatus {
+		functions = append(functions, commitStatusFunction)
+	}
+	if provisionOptions.Notifications && provisionOptions.NotificationWebhook != "" {
+		functions = append(functions, notificationFunction)
+	}
+	return functions
+}
+
+// packageFunction builds the function's bootstrap binary for the
+// provided.al2 runtime and returns the S3 key of its package. The key
+// only depends on the binary, so Provision and Diff resolve the same key
+// for the same code. The package is uploaded to the S3 bucket if upload is
+// true.
+func packageFunction(function *pipelineFunction,
+	provisionOptions *ProvisionOptions,
+	upload bool,
+	awsSession *session.Session,
+	logger *logrus.Logger) (string, error) {
+	buildDir, buildDirErr := ioutil.TempDir("", function.handlerName)
+	if buildDirErr != nil {
+		return "", buildDirErr
+	}
+	defer os.RemoveAll(buildDir)
+
+	binaryPath := filepath.Join(buildDir, lambdaBootstrap)
+	buildCmd := exec.Command("go", "build", "-trimpath", "-tags", "lambda.norpc", "-o", binaryPath, function.importPath)
+	buildCmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0")
+	buildOutput, buildErr := buildCmd.CombinedOutput()
+	if buildErr != nil {
+		return "", fmt.Errorf("Failed to build %s: %s\n%s", function.importPath, buildErr, buildOutput)
+	}
+	binaryBytes, binaryBytesErr := ioutil.ReadFile(binaryPath)
+	if binaryBytesErr != nil {
+		return "", binaryBytesErr
+	}
+	binaryHash := sha256.Sum256(binaryBytes)
+	codeKey := function.codeKey(provisionOptions.PipelineName,
+		hex.EncodeToString(binaryHash[:])[:12])
+	if !upload {
+		logger.WithFields(logrus.Fields{
+			"Function": function.handlerName,
+			"Key":      codeKey,
+		}).Info("Bypassing function package upload")
+		return codeKey, nil
+	}
+
+	zipPath := filepath.Join(buildDir, function.handlerName+".zip")
+	zipErr := writeFunctionZip(zipPath, lambdaBootstrap, binaryBytes)
+	if zipErr != nil {
+		return "", zipErr
+	}
+	_, uploadErr := spartaS3.UploadLocalFileToS3(zipPath,
+		awsSession,
+		provisionOptions.S3Bucket,
+		codeKey,
+		logger)
+	if uploadErr != nil {
+		return "", uploadErr
+	}
+	logger.WithFields(logrus.Fields{
+		"Function": function.handlerName,
+		"Bucket":   provisionOptions.S3Bucket,
+		"Key":      codeKey,
+	}).Info("Function package")
+	return codeKey, nil
+}
+
+// packageFunctions packages every function the options provision and sets
+// the default of its package S3 key parameter. Diff and --noop resolve
+// the keys without uploading the packages.
+func packageFunctions(cfTemplate *gocf.Template,
+	provisionOptions *ProvisionOptions,
+	upload bool,
+	awsSession *session.Session,
+	logger *logrus.Logger) error {
+	for _, eachFunction := range pipelineFunctions(provisionOptions) {
+		codeKey, codeKeyErr := packageFunction(eachFunction,
+			provisionOptions,
+			upload,
+			awsSession,
+			logger)
+		if codeKeyErr != nil {
+			return codeKeyErr
+		}
+		cfTemplate.Parameters[eachFunction.codeKeyParam].Default = codeKey
+	}
+	return nil
+}
+
+// writeFunctionZip writes a Lambda package with the executable binary
+func writeFunctionZip(zipPath string, binaryName string, binaryBytes []byte) error {
+	zipFile, zipFileErr := os.Create(zipPath)
+	if zipFileErr != nil {
+		return zipFileErr
+	}
+	defer zipFile.Close()
+	zipWriter := zip.NewWriter(zipFile)
+	header := &zip.FileHeader{
+		Name:   binaryName,
+		Method: zip.Deflate,
+	}
+	header.SetMode(0755)
+	entryWriter, entryWriterErr := zipWriter.CreateHeader(header)
+	if entryWriterErr != nil {
+		return entryWriterErr
+	}
+	_, writeErr := entryWriter.Write(binaryBytes)
+	if writeErr != nil {
+		return writeErr
+	}
+	return zipWriter.Close()
+}
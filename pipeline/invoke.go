@@ -0,0 +1,175 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+
+	"github.com/mweagle/Sparta"
+)
+
+// InvokeLocalOptions are the options for the invokeLocal command
+type InvokeLocalOptions struct {
+	// Environment is the name of the registered environment whose
+	// variables the function runs with
+	Environment  string `validate:"required"`
+	FunctionName string `validate:"required"`
+	// EventFile is the optional JSON event to invoke the function with
+	EventFile string
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// localFunction is a Lambda function that InvokeLocal can run
+type localFunction struct {
+	name    string
+	handler interface{}
+}
+
+// localFunctions are the functions created by HandleAWSLambda
+var localFunctions = make(map[*sparta.LambdaAWSInfo]localFunction)
+
+// HandleAWSLambda returns sparta.HandleAWSLambda and records the function
+// name and handler so that InvokeLocal can run the function
+func HandleAWSLambda(functionName string,
+	lambdaHandler interface{},
+	roleNameOrIAMRoleDefinition interface{}) *sparta.LambdaAWSInfo {
+	lambdaFn := sparta.HandleAWSLambda(functionName,
+		lambdaHandler,
+		roleNameOrIAMRoleDefinition)
+	localFunctions[lambdaFn] = localFunction{
+		name:    functionName,
+		handler: lambdaHandler,
+	}
+	return lambdaFn
+}
+
+// localHandler returns the handler of the named function
+func localHandler(functionName string,
+	lambdaFunctions []*sparta.LambdaAWSInfo) (interface{}, error) {
+	names := make([]string, 0, len(lambdaFunctions))
+	for _, eachFunction := range lambdaFunctions {
+		local, localOk := localFunctions[eachFunction]
+		if !localOk {
+			continue
+		}
+		if local.name == functionName {
+			return local.handler, nil
+		}
+		names = append(names, local.name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("Function %s doesn't exist (functions: %v)", functionName, names)
+}
+
+// invokeHandler calls the Lambda handler function with the JSON event.
+// Handlers follow the Lambda Go signature rules: an optional
+// context.Context followed by an optional event, returning an optional
+// value and an optional error.
+func invokeHandler(ctx context.Context,
+	handler interface{},
+	eventBytes []byte) (interface{}, error) {
+	handlerValue := reflect.ValueOf(handler)
+	handlerType := handlerValue.Type()
+	if handlerType.Kind() != reflect.Func {
+		return nil, fmt.Errorf("Handler is a %s, not a function", handlerType.Kind())
+	}
+	if handlerType.NumIn() > 2 || handlerType.NumOut() > 2 {
+		return nil, fmt.Errorf("Handler %s has an unsupported signature", handlerType)
+	}
+	args := make([]reflect.Value, 0, handlerType.NumIn())
+	for eachIndex := 0; eachIndex < handlerType.NumIn(); eachIndex++ {
+		argType := handlerType.In(eachIndex)
+		if eachIndex == 0 && argType.Implements(contextType) {
+			args = append(args, reflect.ValueOf(ctx))
+			continue
+		}
+		event := reflect.New(argType)
+		if len(eventBytes) != 0 {
+			unmarshalErr := json.Unmarshal(eventBytes, event.Interface())
+			if unmarshalErr != nil {
+				return nil, fmt.Errorf("Failed to unmarshal the event into %s: %s", argType, unmarshalErr)
+			}
+		}
+		args = append(args, event.Elem())
+	}
+
+	var result interface{}
+	var resultErr error
+	for _, eachValue := range handlerValue.Call(args) {
+		if eachValue.Type().Implements(errorType) {
+			if !eachValue.IsNil() {
+				resultErr = eachValue.Interface().(error)
+			}
+			continue
+		}
+		result = eachValue.Interface()
+	}
+	return result, resultErr
+}
+
+// setEnvironmentVariables sets the environment's variables in the process
+// environment and resolves its secret references
+func setEnvironmentVariables(environment *Environment) error {
+	for eachKey, eachValue := range environmentVariables(environment) {
+		setErr := os.Setenv(eachKey, eachValue)
+		if setErr != nil {
+			return setErr
+		}
+	}
+	return ResolveSecretEnvironment()
+}
+
+// InvokeLocal runs the named function in-process with the variables of the
+// registered environment and writes its JSON result. Only functions created
+// by HandleAWSLambda can be run. A handler error is written and returned.
+func InvokeLocal(options *InvokeLocalOptions,
+	lambdaFunctions []*sparta.LambdaAWSInfo,
+	writer io.Writer) error {
+	handler, handlerErr := localHandler(options.FunctionName, lambdaFunctions)
+	if handlerErr != nil {
+		return handlerErr
+	}
+	environment, environmentErr := registeredEnvironment(options.Environment)
+	if environmentErr != nil {
+		return environmentErr
+	}
+	var eventBytes []byte
+	if options.EventFile != "" {
+		var eventBytesErr error
+		eventBytes, eventBytesErr = ioutil.ReadFile(options.EventFile)
+		if eventBytesErr != nil {
+			return eventBytesErr
+		}
+		if !json.Valid(eventBytes) {
+			return fmt.Errorf("Event file %s is not valid JSON", options.EventFile)
+		}
+	}
+	variablesErr := setEnvironmentVariables(environment)
+	if variablesErr != nil {
+		return variablesErr
+	}
+
+	result, resultErr := invokeHandler(context.Background(), handler, eventBytes)
+	if resultErr != nil {
+		fmt.Fprintf(writer, "Error: %s\n", resultErr)
+		return fmt.Errorf("Function %s failed in environment %s: %s",
+			options.FunctionName,
+			options.Environment,
+			resultErr)
+	}
+	resultBytes, resultBytesErr := json.MarshalIndent(result, "", "  ")
+	if resultBytesErr != nil {
+		return resultBytesErr
+	}
+	fmt.Fprintf(writer, "%s\n", resultBytes)
+	return nil
+}
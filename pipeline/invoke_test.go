@@ -0,0 +1,108 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/mweagle/Sparta"
+)
+
+type testInvokeEvent struct {
+	Name string `json:"name"`
+}
+
+func TestInvokeHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		handler     interface{}
+		event       string
+		expected    interface{}
+		expectedErr bool
+	}{
+		{
+			name: "no arguments",
+			handler: func() (string, error) {
+				return "Hello!", nil
+			},
+			expected: "Hello!",
+		},
+		{
+			name: "context and event",
+			handler: func(ctx context.Context, event testInvokeEvent) (string, error) {
+				return "Hello " + event.Name + "!", nil
+			},
+			event:    `{"name": "World"}`,
+			expected: "Hello World!",
+		},
+		{
+			name: "event without a file",
+			handler: func(event testInvokeEvent) string {
+				return "Hello " + event.Name + "!"
+			},
+			expected: "Hello !",
+		},
+		{
+			name: "error only",
+			handler: func(ctx context.Context) error {
+				return errors.New("failed")
+			},
+			expectedErr: true,
+		},
+		{
+			name: "invalid event",
+			handler: func(event testInvokeEvent) string {
+				return event.Name
+			},
+			event:       `["World"]`,
+			expectedErr: true,
+		},
+		{
+			name:        "not a function",
+			handler:     "Hello!",
+			expectedErr: true,
+		},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.name, func(t *testing.T) {
+			result, resultErr := invokeHandler(context.Background(),
+				eachTest.handler,
+				[]byte(eachTest.event))
+			if (resultErr != nil) != eachTest.expectedErr {
+				t.Fatalf("Error %v, expected an error: %t", resultErr, eachTest.expectedErr)
+			}
+			if !reflect.DeepEqual(result, eachTest.expected) {
+				t.Errorf("Result %#v, expected %#v", result, eachTest.expected)
+			}
+		})
+	}
+}
+
+func TestLocalHandler(t *testing.T) {
+	helloFunction := &sparta.LambdaAWSInfo{}
+	otherFunction := &sparta.LambdaAWSInfo{}
+	localFunctions[helloFunction] = localFunction{
+		name: "HelloWorld",
+		handler: func() (string, error) {
+			return "Hello!", nil
+		},
+	}
+	defer delete(localFunctions, helloFunction)
+	lambdaFunctions := []*sparta.LambdaAWSInfo{otherFunction, helloFunction}
+
+	handler, handlerErr := localHandler("HelloWorld", lambdaFunctions)
+	if handlerErr != nil {
+		t.Fatal(handlerErr)
+	}
+	result, resultErr := invokeHandler(context.Background(), handler, nil)
+	if resultErr != nil || result != "Hello!" {
+		t.Errorf("Result %#v, error %v", result, resultErr)
+	}
+	if _, missingErr := localHandler("Missing", lambdaFunctions); missingErr == nil {
+		t.Error("Expected an error for a function that doesn't exist")
+	}
+	if _, unregisteredErr := localHandler("HelloWorld", []*sparta.LambdaAWSInfo{otherFunction}); unregisteredErr == nil {
+		t.Error("Expected an error for a function that isn't in the service")
+	}
+}
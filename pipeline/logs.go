@@ -0,0 +1,191 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/aws/aws-sdk-go/service/codebuild/codebuildiface"
+	"github.com/aws/aws-sdk-go/service/codepipeline"
+	"github.com/aws/aws-sdk-go/service/codepipeline/codepipelineiface"
+	"github.com/mweagle/Sparta"
+	spartaAWS "github.com/mweagle/Sparta/aws"
+	"github.com/sirupsen/logrus"
+)
+
+// logPollInterval is how often the log group is polled with --follow
+const logPollInterval = 5 * time.Second
+
+// failedCommandRE extracts the failing command from a CodeBuild phase context
+var failedCommandRE = regexp.MustCompile(`Error while executing command: (.*)\. Reason: (.*)`)
+
+// LogsOptions are the command line options necessary to view the
+// CodeBuild logs for a pipeline execution
+type LogsOptions struct {
+	PipelineName string `validate:"required"`
+	ExecutionID  string
+	Follow       bool
+}
+
+// latestBuildID returns the CodeBuild build ID for the Build action of
+// the given, or most recent, pipeline execution
+func latestBuildID(client codepipelineiface.CodePipelineAPI,
+	codePipelineName string,
+	executionID string) (string, error) {
+	listInput := &codepipeline.ListActionExecutionsInput{
+		PipelineName: aws.String(codePipelineName),
+	}
+	if executionID != "" {
+		listInput.Filter = &codepipeline.ActionExecutionFilter{
+			PipelineExecutionId: aws.String(executionID),
+		}
+	}
+	buildID := ""
+	listErr := client.ListActionExecutionsPages(listInput,
+		func(page *codepipeline.ListActionExecutionsOutput, lastPage bool) bool {
+			// Action executions are returned most recent first
+			for _, eachDetail := range page.ActionExecutionDetails {
+				if aws.StringValue(eachDetail.ActionName) != buildActionName ||
+					eachDetail.Output == nil ||
+					eachDetail.Output.ExecutionResult == nil {
+					continue
+				}
+				buildID = aws.StringValue(eachDetail.Output.ExecutionResult.ExternalExecutionId)
+				return false
+			}
+			return true
+		})
+	if listErr != nil {
+		return "", listErr
+	}
+	if buildID == "" {
+		return "", fmt.Errorf("Unable to find a %s action execution for pipeline %s",
+			buildActionName,
+			codePipelineName)
+	}
+	return buildID, nil
+}
+
+// describeBuild returns the CodeBuild build with the given ID
+func describeBuild(client codebuildiface.CodeBuildAPI,
+	buildID string) (*codebuild.Build, error) {
+	buildsOutput, buildsErr := client.BatchGetBuilds(&codebuild.BatchGetBuildsInput{
+		Ids: []*string{aws.String(buildID)},
+	})
+	if buildsErr != nil {
+		return nil, buildsErr
+	}
+	if len(buildsOutput.Builds) == 0 {
+		return nil, fmt.Errorf("CodeBuild build %s not found", buildID)
+	}
+	return buildsOutput.Builds[0], nil
+}
+
+// writeLogEvents writes the log events after nextToken and returns the
+// token to use for the next request
+func writeLogEvents(client cloudwatchlogsiface.CloudWatchLogsAPI,
+	logs *codebuild.LogsLocation,
+	nextToken *string,
+	writer io.Writer) (*string, error) {
+	for {
+		eventsOutput, eventsErr := client.GetLogEvents(&cloudwatchlogs.GetLogEventsInput{
+			LogGroupName:  logs.GroupName,
+			LogStreamName: logs.StreamName,
+			StartFromHead: aws.Bool(true),
+			NextToken:     nextToken,
+		})
+		if eventsErr != nil {
+			return nextToken, eventsErr
+		}
+		for _, eachEvent := range eventsOutput.Events {
+			fmt.Fprint(writer, aws.StringValue(eachEvent.Message))
+		}
+		// The same token is returned once the end of the stream is reached
+		if aws.StringValue(eventsOutput.NextForwardToken) == aws.StringValue(nextToken) {
+			return nextToken, nil
+		}
+		nextToken = eventsOutput.NextForwardToken
+	}
+}
+
+// writeFailureSummary writes the failed buildspec phases and the
+// command that failed in each
+func writeFailureSummary(build *codebuild.Build, writer io.Writer) {
+	for _, eachPhase := range build.Phases {
+		if aws.StringValue(eachPhase.PhaseStatus) != codebuild.StatusTypeFailed {
+			continue
+		}
+		fmt.Fprintf(writer, "\nPhase %s FAILED\n", aws.StringValue(eachPhase.PhaseType))
+		for _, eachContext := range eachPhase.Contexts {
+			message := aws.StringValue(eachContext.Message)
+			matches := failedCommandRE.FindStringSubmatch(message)
+			if len(matches) == 3 {
+				fmt.Fprintf(writer, "  Command: %s\n  Reason:  %s\n", matches[1], matches[2])
+			} else if message != "" {
+				fmt.Fprintf(writer, "  %s: %s\n",
+					aws.StringValue(eachContext.StatusCode),
+					message)
+			}
+		}
+	}
+}
+
+// Logs is responsible for writing the CodeBuild logs for the Build action
+// of a pipeline execution
+func Logs(logsOptions *LogsOptions, writer io.Writer) error {
+	logger, loggerErr := sparta.NewLogger("info")
+	if loggerErr != nil {
+		return loggerErr
+	}
+	awsSession := spartaAWS.NewSession(logger)
+	codePipelineName, codePipelineNameErr := resolvePipelineName(logsOptions.PipelineName,
+		awsSession)
+	if codePipelineNameErr != nil {
+		return codePipelineNameErr
+	}
+	buildID, buildIDErr := latestBuildID(codepipeline.New(awsSession),
+		codePipelineName,
+		logsOptions.ExecutionID)
+	if buildIDErr != nil {
+		return buildIDErr
+	}
+	codeBuildSvc := codebuild.New(awsSession)
+	build, buildErr := describeBuild(codeBuildSvc, buildID)
+	if buildErr != nil {
+		return buildErr
+	}
+	logger.WithFields(logrus.Fields{
+		"BuildID": buildID,
+		"Status":  aws.StringValue(build.BuildStatus),
+	}).Info("CodeBuild build")
+
+	logsSvc := cloudwatchlogs.New(awsSession)
+	var nextToken *string
+	for {
+		if build.Logs != nil && build.Logs.StreamName != nil {
+			token, writeErr := writeLogEvents(logsSvc, build.Logs, nextToken, writer)
+			if writeErr != nil {
+				return writeErr
+			}
+			nextToken = token
+		}
+		if !logsOptions.Follow ||
+			aws.StringValue(build.BuildStatus) != codebuild.StatusTypeInProgress {
+			break
+		}
+		time.Sleep(logPollInterval)
+		build, buildErr = describeBuild(codeBuildSvc, buildID)
+		if buildErr != nil {
+			return buildErr
+		}
+	}
+	if aws.StringValue(build.BuildStatus) == codebuild.StatusTypeFailed {
+		writeFailureSummary(build, writer)
+	}
+	return nil
+}
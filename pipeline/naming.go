@@ -0,0 +1,128 @@
+package pipeline
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mweagle/Sparta"
+)
+
+// nameHashLength is the length of the hash suffix that keeps truncated
+// names unique
+const nameHashLength = 8
+
+// invalidNameCharsRE matches the characters that aren't allowed in
+// stack, change set and CodeBuild project names
+var invalidNameCharsRE = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// pipelineStackName returns the name of the stack that provisions the named
+// pipeline. It's derived from the service and pipeline name only so that
+// the commands that manage an existing pipeline don't need the branch.
+// Ephemeral pipelines use namingPolicy.pipelineStackName instead.
+func pipelineStackName(pipelineName string) string {
+	return fmt.Sprintf("%s-%s",
+		sparta.OptionsGlobal.ServiceName,
+		pipelineName)
+}
+
+// namingPolicy derives the physical names of the resources created by, and
+// for, a pipeline from the service name, pipeline name and branch so that
+// several pipelines for the same service don't collide
+type namingPolicy struct {
+	serviceName  string
+	pipelineName string
+	branch       string
+	// ephemeral pipelines have a pipeline stack per branch
+	ephemeral bool
+}
+
+// newNamingPolicy returns the naming policy for the pipeline and branch
+func newNamingPolicy(pipelineName string, branch string, ephemeral bool) *namingPolicy {
+	return &namingPolicy{
+		serviceName:  sparta.OptionsGlobal.ServiceName,
+		pipelineName: pipelineName,
+		branch:       branch,
+		ephemeral:    ephemeral,
+	}
+}
+
+// provisionStackName returns the name of the pipeline stack that
+// Provision creates or updates
+func provisionStackName(provisionOptions *ProvisionOptions) (string, error) {
+	source, sourceErr := pipelineSource(provisionOptions)
+	if sourceErr != nil {
+		return "", sourceErr
+	}
+	return newNamingPolicy(provisionOptions.PipelineName,
+		source.Branch,
+		provisionOptions.Ephemeral).pipelineStackName()
+}
+
+// physicalName joins the prefix, service, pipeline and branch names with
+// hyphens after replacing unsupported characters. Names longer than
+// maxLength are truncated and suffixed with a hash of the full name.
+func (policy *namingPolicy) physicalName(prefix string, maxLength int) (string, error) {
+	nameParts := []string{prefix,
+		policy.serviceName,
+		policy.pipelineName,
+		policy.branch}
+	sanitizedParts := make([]string, 0, len(nameParts))
+	for _, eachPart := range nameParts {
+		sanitized := strings.Trim(invalidNameCharsRE.ReplaceAllString(eachPart, "-"), "-")
+		if sanitized != "" {
+			sanitizedParts = append(sanitizedParts, sanitized)
+		}
+	}
+	name := strings.Join(sanitizedParts, "-")
+	if len(name) > maxLength {
+		nameHash := sha1.Sum([]byte(name))
+		name = fmt.Sprintf("%s-%s",
+			strings.TrimRight(name[:maxLength-nameHashLength-1], "-"),
+			hex.EncodeToString(nameHash[:])[:nameHashLength])
+	}
+	if !stackNameRE.MatchString(name) {
+		nameKind := prefix
+		if nameKind == "" {
+			nameKind = "pipeline stack"
+		}
+		return "", fmt.Errorf("Failed to derive a valid %s name from service %s, pipeline %s and branch %s",
+			nameKind,
+			policy.serviceName,
+			policy.pipelineName,
+			policy.branch)
+	}
+	return name, nil
+}
+
+// pipelineStackName returns the name of the pipeline stack. Ephemeral
+// pipelines include the branch so that they don't update the stack of the
+// pipeline with the same name.
+func (policy *namingPolicy) pipelineStackName() (string, error) {
+	if !policy.ephemeral {
+		return pipelineStackName(policy.pipelineName), nil
+	}
+	return policy.physicalName("", maxStackNameLength)
+}
+
+// codeBuildProjectName returns the CodeBuild project name
+func (policy *namingPolicy) codeBuildProjectName() (string, error) {
+	return policy.physicalName("CodeBuild", maxCodeBuildNameLength)
+}
+
+// pullRequestProjectName returns the pull request CodeBuild project name
+func (policy *namingPolicy) pullRequestProjectName() (string, error) {
+	return policy.physicalName("PullRequests", maxCodeBuildNameLength)
+}
+
+// environmentStackName returns the default stack name of the environment
+func (policy *namingPolicy) environmentStackName(environmentName string) (string, error) {
+	return policy.physicalName(environmentTitle(environmentName), maxStackNameLength)
+}
+
+// environmentChangeSetName returns the change set name of the environment
+func (policy *namingPolicy) environmentChangeSetName(environmentName string) (string, error) {
+	return policy.physicalName(environmentTitle(environmentName)+"ChangeSet", maxChangeSetNameLength)
+}
@@ -0,0 +1,43 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEphemeralPipelineStackName(t *testing.T) {
+	tests := []struct {
+		name     string
+		branch   string
+		expected string
+	}{
+		{"branch", "develop", "MyService-SpartaPipeline-develop"},
+		{"branch with slashes", "feature/x", "MyService-SpartaPipeline-feature-x"},
+		{"long branch", strings.Repeat("b", maxStackNameLength), ""},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.name, func(t *testing.T) {
+			policy := &namingPolicy{
+				serviceName:  "MyService",
+				pipelineName: "SpartaPipeline",
+				branch:       eachTest.branch,
+				ephemeral:    true,
+			}
+			stackName, stackNameErr := policy.pipelineStackName()
+			if stackNameErr != nil {
+				t.Fatal(stackNameErr)
+			}
+			if len(stackName) > maxStackNameLength || !stackNameRE.MatchString(stackName) {
+				t.Errorf("Invalid stack name %q", stackName)
+			}
+			if eachTest.expected != "" && stackName != eachTest.expected {
+				t.Errorf("Stack name %q, expected %q", stackName, eachTest.expected)
+			}
+			policy.branch = "master"
+			otherStackName, _ := policy.pipelineStackName()
+			if otherStackName == stackName {
+				t.Errorf("Branches %s and master have the same stack name %q", eachTest.branch, stackName)
+			}
+		})
+	}
+}
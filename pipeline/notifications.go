@@ -0,0 +1,321 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/mweagle/Sparta"
+	spartaIAM "github.com/mweagle/Sparta/aws/iam"
+	gocf "github.com/mweagle/go-cloudformation"
+)
+
+// Logical resource names of the optional pipeline notifications
+var (
+	notificationTopicResource = sparta.CloudFormationResourceName("NotificationTopic",
+		"NotificationTopic")
+	notificationTopicPolicyResource = sparta.CloudFormationResourceName("NotificationTopicPolicy",
+		"NotificationTopicPolicy")
+	notificationRoleResource = sparta.CloudFormationResourceName("NotificationRole",
+		"NotificationRole")
+	notificationFunctionResource = sparta.CloudFormationResourceName("NotificationFunction",
+		"NotificationFunction")
+	notificationSubscriptionResource = sparta.CloudFormationResourceName("NotificationSubscription",
+		"NotificationSubscription")
+	notificationPermissionResource = sparta.CloudFormationResourceName("NotificationPermission",
+		"NotificationPermission")
+)
+
+// Environment variables that configure the notification formatter.
+// WEBHOOK_URL is a secret reference, or the URL itself for local runs.
+const (
+	envWebhookURL    = "WEBHOOK_URL"
+	envWebhookFormat = "WEBHOOK_FORMAT"
+)
+
+// notificationFunction is the Lambda function that formats the
+// notifications as Slack or Teams webhook payloads
+var notificationFunction = &pipelineFunction{
+	handlerName:  "notifier",
+	importPath:   "github.com/mweagle/SpartaCodePipeline/cmd/notifier",
+	codeKeyParam: "NotifierCodeKey",
+}
+
+// notificationRules are the pipeline state changes that are published to
+// the notification topic
+var notificationRules = []struct {
+	name       string
+	detailType string
+	states     []string
+}{
+	{"PipelineExecution",
+		"CodePipeline Pipeline Execution State Change",
+		[]string{"SUCCEEDED", "FAILED", "CANCELED", "SUPERSEDED"}},
+	{"StageExecution",
+		"CodePipeline Stage Execution State Change",
+		[]string{"FAILED"}},
+	{"ActionExecution",
+		"CodePipeline Action Execution State Change",
+		[]string{"FAILED"}},
+}
+
+// addNotifications adds the EventBridge rules that publish the pipeline
+// state changes to an SNS topic and, if there is a webhook, the function
+// that forwards them to Slack or Teams
+func addNotifications(cfTemplate *gocf.Template,
+	provisionOptions *ProvisionOptions) {
+	notificationTopic := &gocf.SNSTopic{
+		DisplayName: gocf.String(fmt.Sprintf("%s pipeline notifications",
+			sparta.OptionsGlobal.ServiceName)),
+	}
+	cfTemplate.AddResource(notificationTopicResource, notificationTopic)
+
+	// EventBridge needs permission to publish to the topic
+	topicPolicy := &gocf.SNSTopicPolicy{
+		PolicyDocument: sparta.ArbitraryJSONObject{
+			"Version": "2012-10-17",
+			"Statement": []sparta.ArbitraryJSONObject{
+				{
+					"Effect": "Allow",
+					"Principal": sparta.ArbitraryJSONObject{
+						"Service": "events.amazonaws.com",
+					},
+					"Action":   "sns:Publish",
+					"Resource": gocf.Ref(notificationTopicResource),
+				},
+			},
+		},
+		Topics: gocf.StringList(gocf.Ref(notificationTopicResource)),
+	}
+	cfTemplate.AddResource(notificationTopicPolicyResource, topicPolicy)
+
+	for _, eachRule := range notificationRules {
+		rule := &gocf.EventsRule{
+			Description: gocf.String(eachRule.detailType),
+			EventPattern: sparta.ArbitraryJSONObject{
+				"source":      []string{"aws.codepipeline"},
+				"detail-type": []string{eachRule.detailType},
+				"detail": sparta.ArbitraryJSONObject{
+					"pipeline": []interface{}{gocf.Ref(codePipelineResource)},
+					"state":    eachRule.states,
+				},
+			},
+			State: gocf.String("ENABLED"),
+			Targets: &gocf.EventsRuleTargetList{
+				gocf.EventsRuleTarget{
+					Arn: gocf.Ref(notificationTopicResource).String(),
+					ID:  gocf.String("NotificationTopic"),
+				},
+			},
+		}
+		cfTemplate.AddResource(sparta.CloudFormationResourceName("NotificationRule", eachRule.name),
+			rule)
+	}
+	cfTemplate.Outputs["NotificationTopicArn"] = &gocf.Output{
+		Description: "Pipeline notification SNS topic ARN",
+		Value:       gocf.Ref(notificationTopicResource),
+	}
+	if provisionOptions.NotificationWebhook == "" {
+		return
+	}
+
+	notificationFunction.addCodeKeyParameter(cfTemplate, provisionOptions.PipelineName)
+	webhookActions, webhookResource := parseSecretReference(provisionOptions.NotificationWebhook).privilege()
+	notificationRole := &gocf.IAMRole{
+		Path:                     gocf.String("/"),
+		AssumeRolePolicyDocument: AssumePolicyLambdaRoleDocument,
+		Policies: &gocf.IAMRolePolicyList{
+			gocf.IAMRolePolicy{
+				PolicyName: gocf.String("NotificationRole"),
+				PolicyDocument: sparta.ArbitraryJSONObject{
+					"Version": "2012-10-17",
+					"Statement": []spartaIAM.PolicyStatement{
+						spartaIAM.PolicyStatement{
+							Action: []string{"logs:CreateLogGroup",
+								"logs:CreateLogStream",
+								"logs:PutLogEvents"},
+							Effect:   "Allow",
+							Resource: gocf.String("*"),
+						},
+						spartaIAM.PolicyStatement{
+							Action:   webhookActions,
+							Effect:   "Allow",
+							Resource: webhookResource,
+						},
+					},
+				},
+			},
+		},
+	}
+	cfTemplate.AddResource(notificationRoleResource, notificationRole)
+
+	formatterFunction := &gocf.LambdaFunction{
+		Description: gocf.String("Forwards pipeline notifications to a chat webhook"),
+		Code:        notificationFunction.code(provisionOptions.S3Bucket),
+		Handler:     gocf.String(lambdaBootstrap),
+		Runtime:     gocf.String(lambdaRuntime),
+		Role:        gocf.GetAtt(notificationRoleResource, "Arn"),
+		Timeout:     gocf.Integer(30),
+		Environment: &gocf.LambdaFunctionEnvironment{
+			Variables: map[string]interface{}{
+				envWebhookURL:    provisionOptions.NotificationWebhook,
+				envWebhookFormat: provisionOptions.NotificationFormat,
+			},
+		},
+	}
+	cfTemplate.AddResource(notificationFunctionResource, formatterFunction)
+
+	subscription := &gocf.SNSSubscription{
+		Protocol: gocf.String("lambda"),
+		Endpoint: gocf.GetAtt(notificationFunctionResource, "Arn"),
+		TopicArn: gocf.Ref(notificationTopicResource).String(),
+	}
+	cfTemplate.AddResource(notificationSubscriptionResource, subscription)
+
+	permission := &gocf.LambdaPermission{
+		Action:       gocf.String("lambda:InvokeFunction"),
+		FunctionName: gocf.GetAtt(notificationFunctionResource, "Arn"),
+		Principal:    gocf.String("sns.amazonaws.com"),
+		SourceArn:    gocf.Ref(notificationTopicResource).String(),
+	}
+	cfTemplate.AddResource(notificationPermissionResource, permission)
+}
+
+// pipelineStateChangeEvent is a CodePipeline execution, stage or action
+// state change event
+type pipelineStateChangeEvent struct {
+	DetailType string `json:"detail-type"`
+	Region     string `json:"region"`
+	Detail     struct {
+		Pipeline    string `json:"pipeline"`
+		ExecutionID string `json:"execution-id"`
+		Stage       string `json:"stage"`
+		Action      string `json:"action"`
+		State       string `json:"state"`
+	} `json:"detail"`
+}
+
+// SNSEvent is the subset of the SNS Lambda event used by the
+// notification formatter
+type SNSEvent struct {
+	Records []struct {
+		SNS struct {
+			Message string `json:"Message"`
+		} `json:"Sns"`
+	} `json:"Records"`
+}
+
+// NotificationFormatter forwards pipeline state change events published
+// to the notification topic to a Slack or Teams incoming webhook
+type NotificationFormatter struct {
+	// WebhookURL returns the incoming webhook URL
+	WebhookURL func() (string, error)
+	// Format is either slack or teams
+	Format string
+	Client *http.Client
+}
+
+// NewNotificationFormatter returns a formatter configured by the
+// WEBHOOK_* environment variables. A secret reference in WEBHOOK_URL is
+// resolved once per container.
+func NewNotificationFormatter(awsSession *session.Session) *NotificationFormatter {
+	webhookURL := os.Getenv(envWebhookURL)
+	reference := parseSecretReference(webhookURL)
+	var webhookURLOnce sync.Once
+	var webhookURLErr error
+	return &NotificationFormatter{
+		WebhookURL: func() (string, error) {
+			webhookURLOnce.Do(func() {
+				if reference != nil {
+					webhookURL, webhookURLErr = resolveSecretReference(reference, awsSession)
+				}
+			})
+			return webhookURL, webhookURLErr
+		},
+		Format: os.Getenv(envWebhookFormat),
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// notificationText returns the one line description of the event
+func notificationText(event *pipelineStateChangeEvent) string {
+	subject := event.Detail.Pipeline
+	if event.Detail.Stage != "" {
+		subject = fmt.Sprintf("%s %s", subject, event.Detail.Stage)
+	}
+	if event.Detail.Action != "" {
+		subject = fmt.Sprintf("%s/%s", subject, event.Detail.Action)
+	}
+	return fmt.Sprintf("%s %s (execution %s)",
+		subject,
+		event.Detail.State,
+		event.Detail.ExecutionID)
+}
+
+// webhookPayload returns the Slack or Teams message for the event
+func (formatter *NotificationFormatter) webhookPayload(event *pipelineStateChangeEvent) interface{} {
+	text := notificationText(event)
+	consoleURL := fmt.Sprintf("https://console.aws.amazon.com/codesuite/codepipeline/pipelines/%s/view?region=%s",
+		event.Detail.Pipeline,
+		event.Region)
+	color := "2EB886"
+	if event.Detail.State != "SUCCEEDED" {
+		color = "D00000"
+	}
+	if formatter.Format == "teams" {
+		return map[string]interface{}{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    text,
+			"themeColor": color,
+			"title":      event.DetailType,
+			"text":       fmt.Sprintf("%s\n\n[View pipeline](%s)", text, consoleURL),
+		}
+	}
+	return map[string]interface{}{
+		"text": text,
+		"attachments": []map[string]interface{}{
+			{
+				"color":      "#" + color,
+				"title":      event.DetailType,
+				"title_link": consoleURL,
+			},
+		},
+	}
+}
+
+// Handle posts every pipeline state change in the SNS event to the webhook
+func (formatter *NotificationFormatter) Handle(ctx context.Context, snsEvent SNSEvent) error {
+	webhookURL, webhookURLErr := formatter.WebhookURL()
+	if webhookURLErr != nil {
+		return webhookURLErr
+	}
+	for _, eachRecord := range snsEvent.Records {
+		var event pipelineStateChangeEvent
+		unmarshalErr := json.Unmarshal([]byte(eachRecord.SNS.Message), &event)
+		if unmarshalErr != nil {
+			return unmarshalErr
+		}
+		payload, payloadErr := json.Marshal(formatter.webhookPayload(&event))
+		if payloadErr != nil {
+			return payloadErr
+		}
+		response, responseErr := formatter.Client.Post(webhookURL,
+			"application/json",
+			bytes.NewReader(payload))
+		if responseErr != nil {
+			return responseErr
+		}
+		response.Body.Close()
+		if response.StatusCode/100 != 2 {
+			return fmt.Errorf("Failed to post notification: %s", response.Status)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func testStateChangeEvent(stage string, state string) *pipelineStateChangeEvent {
+	event := &pipelineStateChangeEvent{
+		DetailType: "CodePipeline Stage Execution State Change",
+		Region:     "us-west-2",
+	}
+	event.Detail.Pipeline = "pipeline"
+	event.Detail.ExecutionID = "execution-1"
+	event.Detail.Stage = stage
+	event.Detail.State = state
+	return event
+}
+
+func TestWebhookPayload(t *testing.T) {
+	consoleURL := "https://console.aws.amazon.com/codesuite/codepipeline/pipelines/pipeline/view?region=us-west-2"
+	tests := []struct {
+		name     string
+		format   string
+		event    *pipelineStateChangeEvent
+		expected string
+	}{
+		{
+			name:   "slack succeeded",
+			format: "slack",
+			event:  testStateChangeEvent("", "SUCCEEDED"),
+			expected: `{
+				"text": "pipeline SUCCEEDED (execution execution-1)",
+				"attachments": [{
+					"color": "#2EB886",
+					"title": "CodePipeline Stage Execution State Change",
+					"title_link": "` + consoleURL + `"
+				}]
+			}`,
+		},
+		{
+			name:   "default format failed stage",
+			format: "",
+			event:  testStateChangeEvent("Build", "FAILED"),
+			expected: `{
+				"text": "pipeline Build FAILED (execution execution-1)",
+				"attachments": [{
+					"color": "#D00000",
+					"title": "CodePipeline Stage Execution State Change",
+					"title_link": "` + consoleURL + `"
+				}]
+			}`,
+		},
+		{
+			name:   "teams failed stage",
+			format: "teams",
+			event:  testStateChangeEvent("Build", "FAILED"),
+			expected: `{
+				"@type": "MessageCard",
+				"@context": "https://schema.org/extensions",
+				"summary": "pipeline Build FAILED (execution execution-1)",
+				"themeColor": "D00000",
+				"title": "CodePipeline Stage Execution State Change",
+				"text": "pipeline Build FAILED (execution execution-1)\n\n[View pipeline](` + consoleURL + `)"
+			}`,
+		},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.name, func(t *testing.T) {
+			formatter := &NotificationFormatter{Format: eachTest.format}
+			payloadBytes, payloadBytesErr := json.Marshal(formatter.webhookPayload(eachTest.event))
+			if payloadBytesErr != nil {
+				t.Fatal(payloadBytesErr)
+			}
+			var payload interface{}
+			var expected interface{}
+			if unmarshalErr := json.Unmarshal(payloadBytes, &payload); unmarshalErr != nil {
+				t.Fatal(unmarshalErr)
+			}
+			if unmarshalErr := json.Unmarshal([]byte(eachTest.expected), &expected); unmarshalErr != nil {
+				t.Fatal(unmarshalErr)
+			}
+			if !reflect.DeepEqual(payload, expected) {
+				t.Errorf("Payload %s, expected %s", payloadBytes, eachTest.expected)
+			}
+		})
+	}
+}
+
+func TestNotificationFormatterHandle(t *testing.T) {
+	posted := make([]map[string]interface{}, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		payload := make(map[string]interface{})
+		decodeErr := json.NewDecoder(request.Body).Decode(&payload)
+		if decodeErr != nil {
+			t.Error(decodeErr)
+		}
+		posted = append(posted, payload)
+	}))
+	defer server.Close()
+
+	formatter := &NotificationFormatter{
+		WebhookURL: func() (string, error) {
+			return server.URL, nil
+		},
+		Format: "slack",
+		Client: server.Client(),
+	}
+	// SNS delivers every state change event as a JSON message
+	records := make([]interface{}, 0)
+	for _, eachState := range []string{"SUCCEEDED", "FAILED"} {
+		message, messageErr := json.Marshal(testStateChangeEvent("Build", eachState))
+		if messageErr != nil {
+			t.Fatal(messageErr)
+		}
+		records = append(records, map[string]interface{}{
+			"Sns": map[string]string{"Message": string(message)},
+		})
+	}
+	snsEventBytes, snsEventBytesErr := json.Marshal(map[string]interface{}{"Records": records})
+	if snsEventBytesErr != nil {
+		t.Fatal(snsEventBytesErr)
+	}
+	var snsEvent SNSEvent
+	if unmarshalErr := json.Unmarshal(snsEventBytes, &snsEvent); unmarshalErr != nil {
+		t.Fatal(unmarshalErr)
+	}
+	handleErr := formatter.Handle(context.Background(), snsEvent)
+	if handleErr != nil {
+		t.Fatal(handleErr)
+	}
+	if len(posted) != 2 {
+		t.Fatalf("Posted %d notifications, expected 2", len(posted))
+	}
+	if posted[1]["text"] != "pipeline Build FAILED (execution execution-1)" {
+		t.Errorf("Unexpected notification text: %v", posted[1]["text"])
+	}
+}
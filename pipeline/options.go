@@ -0,0 +1,182 @@
+package pipeline
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/mweagle/Sparta"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+var s3BucketNameRE = regexp.MustCompile(`^[a-z0-9][a-z0-9.\-]{1,61}[a-z0-9]$`)
+var githubTokenRE = regexp.MustCompile(`^([0-9a-f]{40}|gh[pousr]_[A-Za-z0-9]{36,251}|github_pat_[A-Za-z0-9_]{22,255})$`)
+
+// optionFlagNames maps option fields to the command line flags that set them
+var optionFlagNames = map[string]string{
+	"PipelineName":        "--pipeline",
+	"GithubRepo":          "--repo",
+	"GithubOAuthToken":    "--oauth",
+	"S3Bucket":            "--s3Bucket",
+	"Format":              "--format",
+	"Output":              "--output",
+	"Executions":          "--executions",
+	"StageName":           "--stage",
+	"TTL":                 "--ttl",
+	"GitHubAPIURL":        "--githubApiURL",
+	"NotificationWebhook": "--notificationWebhook",
+	"NotificationFormat":  "--notificationFormat",
+	"ConfigDir":           "--configDir",
+	"Environment":         "--env",
+	"EventFile":           "--event",
+}
+
+// gitHubRepo is the GitHub repository parsed from a repository URL
+type gitHubRepo struct {
+	Owner  string
+	Repo   string
+	Branch string
+}
+
+// parseGitHubRepo parses a repository URL of the form
+// https://github.com/owner/repo[/tree/branch]. Everything after /tree/ is
+// the branch name, so branch names may include slashes.
+func parseGitHubRepo(repoURLValue string) (*gitHubRepo, error) {
+	repoURL, repoURLErr := url.Parse(repoURLValue)
+	if repoURLErr != nil {
+		return nil, repoURLErr
+	}
+	if repoURL.Scheme != "https" && repoURL.Scheme != "http" {
+		return nil, fmt.Errorf("%s is not an http(s) URL", repoURLValue)
+	}
+	if !strings.EqualFold(repoURL.Hostname(), "github.com") {
+		return nil, fmt.Errorf("%s is not a github.com URL", repoURLValue)
+	}
+	pathParts := strings.Split(strings.Trim(repoURL.Path, "/"), "/")
+	if len(pathParts) < 2 || pathParts[0] == "" || pathParts[1] == "" {
+		return nil, fmt.Errorf("%s does not include an owner and repository name", repoURLValue)
+	}
+	parsed := &gitHubRepo{
+		Owner:  pathParts[0],
+		Repo:   strings.TrimSuffix(pathParts[1], ".git"),
+		Branch: "master",
+	}
+	if len(pathParts) > 2 {
+		if pathParts[2] != "tree" || len(pathParts) < 4 || pathParts[3] == "" {
+			return nil, fmt.Errorf("%s is not of the form https://github.com/owner/repo[/tree/branch]",
+				repoURLValue)
+		}
+		parsed.Branch = strings.Join(pathParts[3:], "/")
+	}
+	return parsed, nil
+}
+
+// isS3BucketName implements the S3 bucket naming rules
+func isS3BucketName(bucketName string) bool {
+	if !s3BucketNameRE.MatchString(bucketName) ||
+		strings.Contains(bucketName, "..") ||
+		strings.Contains(bucketName, ".-") ||
+		strings.Contains(bucketName, "-.") ||
+		strings.HasPrefix(bucketName, "xn--") {
+		return false
+	}
+	// Bucket names must not be formatted as IP addresses
+	return net.ParseIP(bucketName) == nil
+}
+
+// isPipelineName returns true if the pipeline name produces a valid
+// CloudFormation stack name
+func isPipelineName(pipelineName string) bool {
+	stackName := pipelineStackName(pipelineName)
+	return len(stackName) <= maxStackNameLength && stackNameRE.MatchString(stackName)
+}
+
+// newOptionsValidator returns a validator with the custom option rules
+func newOptionsValidator() *validator.Validate {
+	validate := validator.New()
+	validate.RegisterValidation("githubrepo", func(fl validator.FieldLevel) bool {
+		_, parseErr := parseGitHubRepo(fl.Field().String())
+		return parseErr == nil
+	})
+	validate.RegisterValidation("s3bucket", func(fl validator.FieldLevel) bool {
+		return isS3BucketName(fl.Field().String())
+	})
+	validate.RegisterValidation("pipelinename", func(fl validator.FieldLevel) bool {
+		return isPipelineName(fl.Field().String())
+	})
+	validate.RegisterValidation("githubtoken", func(fl validator.FieldLevel) bool {
+		return githubTokenRE.MatchString(fl.Field().String())
+	})
+	validate.RegisterValidation("secretreference", func(fl validator.FieldLevel) bool {
+		return parseSecretReference(fl.Field().String()) != nil
+	})
+	return validate
+}
+
+// validationMessage returns a friendly description of the failed rule
+func validationMessage(fieldErr validator.FieldError) string {
+	if strings.HasPrefix(fieldErr.Tag(), "eq") {
+		return fmt.Sprintf("has an unsupported value: %v", fieldErr.Value())
+	}
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "githubrepo":
+		return fmt.Sprintf("must be a GitHub repository URL like https://github.com/owner/repo (got %s)",
+			fieldErr.Value())
+	case "s3bucket":
+		return fmt.Sprintf("must be a valid S3 bucket name: 3-63 lowercase letters, numbers, dots and hyphens (got %s)",
+			fieldErr.Value())
+	case "pipelinename":
+		return fmt.Sprintf("must produce a valid stack name (%s) of at most %d letters, numbers and hyphens",
+			pipelineStackName(fmt.Sprintf("%v", fieldErr.Value())),
+			maxStackNameLength)
+	case "secretreference":
+		// Never echo a value that may be the secret itself
+		return "must reference an SSM parameter, ssm:/path, or a Secrets Manager secret, secretsmanager:id[#key]"
+	case "githubtoken":
+		// Never echo the token
+		return "does not look like a GitHub token (expected 40 hex characters or a ghp_/github_pat_ prefixed token)"
+	case "min":
+		return fmt.Sprintf("must be at least %s", fieldErr.Param())
+	case "url":
+		return "must be a URL"
+	case "hexadecimal":
+		return fmt.Sprintf("must be a commit SHA (got %v)", fieldErr.Value())
+	default:
+		return fmt.Sprintf("failed the %s rule", fieldErr.Tag())
+	}
+}
+
+// ValidateOptions validates command options using their struct tags,
+// skipping the named fields, and returns a single error that describes
+// every invalid option
+func ValidateOptions(options interface{}, exceptFields ...string) error {
+	validate := newOptionsValidator()
+	var validateErr error
+	if len(exceptFields) != 0 {
+		validateErr = validate.StructExcept(options, exceptFields...)
+	} else {
+		validateErr = validate.Struct(options)
+	}
+	if validateErr == nil {
+		return nil
+	}
+	fieldErrs, fieldErrsOk := validateErr.(validator.ValidationErrors)
+	if !fieldErrsOk {
+		return validateErr
+	}
+	messages := make([]string, 0, len(fieldErrs))
+	for _, eachErr := range fieldErrs {
+		optionName := eachErr.Field()
+		if flagName, flagNameOk := optionFlagNames[optionName]; flagNameOk {
+			optionName = fmt.Sprintf("%s (%s)", flagName, optionName)
+		}
+		messages = append(messages, fmt.Sprintf("  %s %s", optionName, validationMessage(eachErr)))
+	}
+	return fmt.Errorf("Invalid %s options:\n%s",
+		sparta.OptionsGlobal.ServiceName,
+		strings.Join(messages, "\n"))
+}
@@ -0,0 +1,202 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mweagle/Sparta"
+)
+
+func TestParseGitHubRepo(t *testing.T) {
+	tests := []struct {
+		name     string
+		repoURL  string
+		expected *gitHubRepo
+	}{
+		{
+			name:     "repository",
+			repoURL:  "https://github.com/mweagle/SpartaCodePipeline",
+			expected: &gitHubRepo{"mweagle", "SpartaCodePipeline", "master"},
+		},
+		{
+			name:     "git suffix",
+			repoURL:  "https://github.com/mweagle/SpartaCodePipeline.git",
+			expected: &gitHubRepo{"mweagle", "SpartaCodePipeline", "master"},
+		},
+		{
+			name:     "branch",
+			repoURL:  "https://github.com/mweagle/SpartaCodePipeline/tree/develop",
+			expected: &gitHubRepo{"mweagle", "SpartaCodePipeline", "develop"},
+		},
+		{
+			name:     "branch with slashes",
+			repoURL:  "https://github.com/mweagle/SpartaCodePipeline/tree/feature/x",
+			expected: &gitHubRepo{"mweagle", "SpartaCodePipeline", "feature/x"},
+		},
+		{
+			name:    "other host",
+			repoURL: "https://gitlab.com/mweagle/SpartaCodePipeline",
+		},
+		{
+			name:    "missing repository",
+			repoURL: "https://github.com/mweagle",
+		},
+		{
+			name:    "not a tree URL",
+			repoURL: "https://github.com/mweagle/SpartaCodePipeline/blob/master/main.go",
+		},
+		{
+			name:    "missing branch",
+			repoURL: "https://github.com/mweagle/SpartaCodePipeline/tree/",
+		},
+		{
+			name:    "not http",
+			repoURL: "git@github.com:mweagle/SpartaCodePipeline.git",
+		},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.name, func(t *testing.T) {
+			parsed, parsedErr := parseGitHubRepo(eachTest.repoURL)
+			if eachTest.expected == nil {
+				if parsedErr == nil {
+					t.Errorf("Parsed %s as %+v, expected an error", eachTest.repoURL, parsed)
+				}
+				return
+			}
+			if parsedErr != nil {
+				t.Fatal(parsedErr)
+			}
+			if *parsed != *eachTest.expected {
+				t.Errorf("Parsed %+v, expected %+v", parsed, eachTest.expected)
+			}
+		})
+	}
+}
+
+func TestIsS3BucketName(t *testing.T) {
+	tests := []struct {
+		name       string
+		bucketName string
+		expected   bool
+	}{
+		{"bucket", "my-bucket", true},
+		{"dotted bucket", "my.bucket.name", true},
+		{"minimum length", "abc", true},
+		{"too short", "ab", false},
+		{"maximum length", strings.Repeat("a", 63), true},
+		{"too long", strings.Repeat("a", 64), false},
+		{"uppercase", "My-Bucket", false},
+		{"underscore", "my_bucket", false},
+		{"leading hyphen", "-bucket", false},
+		{"trailing dot", "bucket.", false},
+		{"IP address", "192.168.1.1", false},
+		{"adjacent dots", "my..bucket", false},
+		{"dot hyphen", "my.-bucket", false},
+		{"hyphen dot", "my-.bucket", false},
+		{"xn-- prefix", "xn--bucket", false},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.name, func(t *testing.T) {
+			if valid := isS3BucketName(eachTest.bucketName); valid != eachTest.expected {
+				t.Errorf("Bucket name %q valid %t, expected %t", eachTest.bucketName, valid, eachTest.expected)
+			}
+		})
+	}
+}
+
+func TestGitHubTokenRE(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		expected bool
+	}{
+		{"classic token", strings.Repeat("0123456789abcdef", 3)[:40], true},
+		{"classic token too short", strings.Repeat("a", 39), false},
+		{"classic token uppercase", strings.Repeat("A", 40), false},
+		{"ghp_ token", "ghp_" + strings.Repeat("A1", 18), true},
+		{"ghp_ token too short", "ghp_" + strings.Repeat("a", 35), false},
+		{"gho_ token", "gho_" + strings.Repeat("b", 36), true},
+		{"unknown prefix", "ghx_" + strings.Repeat("a", 36), false},
+		{"github_pat_ token", "github_pat_" + strings.Repeat("a_B", 27), true},
+		{"github_pat_ token too short", "github_pat_" + strings.Repeat("a", 21), false},
+		{"empty", "", false},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.name, func(t *testing.T) {
+			if matched := githubTokenRE.MatchString(eachTest.token); matched != eachTest.expected {
+				t.Errorf("Token %q matched %t, expected %t", eachTest.token, matched, eachTest.expected)
+			}
+		})
+	}
+}
+
+func TestIsPipelineName(t *testing.T) {
+	savedServiceName := sparta.OptionsGlobal.ServiceName
+	defer func() {
+		sparta.OptionsGlobal.ServiceName = savedServiceName
+	}()
+	sparta.OptionsGlobal.ServiceName = "MyService"
+	// The stack name is MyService-<pipelineName>
+	maxPipelineNameLength := maxStackNameLength - len("MyService-")
+	tests := []struct {
+		name         string
+		pipelineName string
+		expected     bool
+	}{
+		{"pipeline", "SpartaPipeline", true},
+		{"stack name limit", strings.Repeat("a", maxPipelineNameLength), true},
+		{"exceeds stack name limit", strings.Repeat("a", maxPipelineNameLength+1), false},
+		{"underscore", "Sparta_Pipeline", false},
+		{"space", "Sparta Pipeline", false},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.name, func(t *testing.T) {
+			if valid := isPipelineName(eachTest.pipelineName); valid != eachTest.expected {
+				t.Errorf("Pipeline name %q valid %t, expected %t", eachTest.pipelineName, valid, eachTest.expected)
+			}
+		})
+	}
+}
+
+func TestValidateOptions(t *testing.T) {
+	savedServiceName := sparta.OptionsGlobal.ServiceName
+	defer func() {
+		sparta.OptionsGlobal.ServiceName = savedServiceName
+	}()
+	sparta.OptionsGlobal.ServiceName = "MyService"
+	tests := []struct {
+		name     string
+		options  *StatusOptions
+		expected string
+	}{
+		{
+			name:    "valid",
+			options: &StatusOptions{PipelineName: "SpartaPipeline", Output: "table"},
+		},
+		{
+			name:    "every invalid option",
+			options: &StatusOptions{Executions: -1, Output: "xml"},
+			expected: "Invalid MyService options:\n" +
+				"  --pipeline (PipelineName) is required\n" +
+				"  --executions (Executions) must be at least 0\n" +
+				"  --output (Output) has an unsupported value: xml",
+		},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.name, func(t *testing.T) {
+			validateErr := ValidateOptions(eachTest.options)
+			if eachTest.expected == "" {
+				if validateErr != nil {
+					t.Errorf("Unexpected error: %s", validateErr)
+				}
+				return
+			}
+			if validateErr == nil {
+				t.Fatalf("Options %+v, expected an error", eachTest.options)
+			}
+			if validateErr.Error() != eachTest.expected {
+				t.Errorf("Error %q, expected %q", validateErr.Error(), eachTest.expected)
+			}
+		})
+	}
+}
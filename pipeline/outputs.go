@@ -0,0 +1,109 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/sirupsen/logrus"
+)
+
+// webhookResource is the logical name of the optional GitHub webhook
+const webhookResource = "GitHubWebhook"
+
+// addTemplateOutputs adds the pipeline stack Outputs
+func addTemplateOutputs(cfTemplate *gocf.Template, includeWebhook bool) {
+	cfTemplate.Outputs["PipelineName"] = &gocf.Output{
+		Description: "CodePipeline name",
+		Value:       gocf.Ref(codePipelineResource),
+	}
+	cfTemplate.Outputs["PipelineConsoleURL"] = &gocf.Output{
+		Description: "CodePipeline console URL",
+		Value: gocf.Join("",
+			gocf.String("https://console.aws.amazon.com/codesuite/codepipeline/pipelines/"),
+			gocf.Ref(codePipelineResource).String(),
+			gocf.String("/view?region="),
+			gocf.Ref("AWS::Region").String()),
+	}
+	cfTemplate.Outputs["ArtifactBucketName"] = &gocf.Output{
+		Description: "Pipeline artifact bucket name",
+		Value:       gocf.Ref(artifactS3BucketResource),
+	}
+	cfTemplate.Outputs["ArtifactBucketArn"] = &gocf.Output{
+		Description: "Pipeline artifact bucket ARN",
+		Value:       gocf.GetAtt(artifactS3BucketResource, "Arn"),
+	}
+	cfTemplate.Outputs["CodeBuildProjectName"] = &gocf.Output{
+		Description: "CodeBuild project name",
+		Value:       gocf.Ref(codeBuildProjectResource),
+	}
+	cfTemplate.Outputs["CodeBuildRoleArn"] = &gocf.Output{
+		Description: "CodeBuild role ARN",
+		Value:       gocf.GetAtt(codeBuildRoleResource, "Arn"),
+	}
+	cfTemplate.Outputs["CodePipelineRoleArn"] = &gocf.Output{
+		Description: "CodePipeline role ARN",
+		Value:       gocf.GetAtt(codePipelineRoleResource, "Arn"),
+	}
+	cfTemplate.Outputs["CloudFormationRoleArn"] = &gocf.Output{
+		Description: "CloudFormation deployment role ARN",
+		Value:       gocf.GetAtt(cfnRoleResource, "Arn"),
+	}
+	if includeWebhook {
+		cfTemplate.Outputs["WebhookURL"] = &gocf.Output{
+			Description: "GitHub webhook URL",
+			Value:       gocf.GetAtt(webhookResource, "Url"),
+		}
+	}
+}
+
+// stackOutputs returns the stack Outputs keyed by OutputKey
+func stackOutputs(stack *cloudformation.Stack) map[string]string {
+	outputs := make(map[string]string, len(stack.Outputs))
+	for _, eachOutput := range stack.Outputs {
+		outputs[aws.StringValue(eachOutput.OutputKey)] = aws.StringValue(eachOutput.OutputValue)
+	}
+	return outputs
+}
+
+// reportStackOutputs logs the pipeline stack Outputs and writes them to
+// the JSON report file
+func reportStackOutputs(stack *cloudformation.Stack,
+	reportPath string,
+	logger *logrus.Logger) error {
+	outputs := stackOutputs(stack)
+	outputKeys := make([]string, 0, len(outputs))
+	for eachKey := range outputs {
+		outputKeys = append(outputKeys, eachKey)
+	}
+	sort.Strings(outputKeys)
+	for _, eachKey := range outputKeys {
+		logger.WithFields(logrus.Fields{
+			eachKey: outputs[eachKey],
+		}).Info("Pipeline stack output")
+	}
+	if reportPath == "" {
+		return nil
+	}
+	jsonBytes, jsonBytesErr := json.MarshalIndent(outputs, "", " ")
+	if jsonBytesErr != nil {
+		return jsonBytesErr
+	}
+	mkdirErr := os.MkdirAll(filepath.Dir(reportPath), os.ModePerm)
+	if mkdirErr != nil {
+		return mkdirErr
+	}
+	writeErr := ioutil.WriteFile(reportPath, jsonBytes, 0644)
+	if writeErr != nil {
+		return writeErr
+	}
+	logger.WithFields(logrus.Fields{
+		"Path": reportPath,
+	}).Info("Pipeline stack outputs report")
+	return nil
+}
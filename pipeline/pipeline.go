@@ -1,16 +1,15 @@
 package pipeline
 
 import (
-	"encoding/json"
 	"fmt"
-	"net/url"
 	"os"
-	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/mweagle/Sparta"
 	spartaAWS "github.com/mweagle/Sparta/aws"
 	spartaCF "github.com/mweagle/Sparta/aws/cloudformation"
@@ -22,14 +21,90 @@ import (
 
 var convergeDivider = strings.Repeat("-", 62)
 
+// Logical resource names in the pipeline stack. These are stable so that
+// commands other than Provision can locate the provisioned resources.
+var (
+	artifactS3BucketResource = sparta.CloudFormationResourceName("S3ArtifactBucket",
+		"S3ArtifactBucket")
+	cfnRoleResource = sparta.CloudFormationResourceName("CloudFormationRole",
+		"CloudFormationRole")
+	codeBuildRoleResource = sparta.CloudFormationResourceName("CodeBuildRole",
+		"CodeBuildRole")
+	codePipelineRoleResource = sparta.CloudFormationResourceName("CodePipelineRole",
+		"CodePipelineRole")
+	codeBuildProjectResource = sparta.CloudFormationResourceName("CodeBuildProject",
+		"CodeBuildProject")
+)
+
+// codePipelineResource is the logical name of the CodePipeline resource
+const codePipelineResource = "BuildPipeline"
+
+// sourceActionName is the name of the GitHub source action
+const sourceActionName = "GitHub"
+
+// buildActionName is the name of the CodeBuild action
+const buildActionName = "Build"
+
 // ProvisionOptions are the command line options necessary to provision
 // the CloudFormation backed CodeBuild pipeline for this project
 type ProvisionOptions struct {
 	Noop             bool
-	S3Bucket         string `validate:"required"`
-	PipelineName     string `validate:"required"`
-	GithubRepo       string `validate:"required"`
-	GithubOAuthToken string `validate:"required"`
+	S3Bucket         string `validate:"required,s3bucket"`
+	PipelineName     string `validate:"required,pipelinename"`
+	GithubRepo       string `validate:"required,githubrepo"`
+	GithubOAuthToken string `validate:"required,githubtoken"`
+	// Webhook triggers the pipeline with a GitHub webhook signed with the
+	// WebhookSecret. The secret is required so that provisioning doesn't
+	// rotate it and re-register the webhook.
+	Webhook       bool
+	WebhookSecret string
+	// Optional VPC configuration for the CodeBuild project
+	VpcID            string
+	SubnetIDs        []string
+	SecurityGroupIDs []string
+	// Optional private Go module access for the CodeBuild project
+	GoPrivate           string
+	GoNoSumDB           string
+	GoProxy             string
+	GitCredentialSecret string
+	GitCredentialType   string `validate:"omitempty,eq=token|eq=ssh"`
+	// Change set preview and confirmation
+	Plan bool
+	Yes  bool
+	// Generated template and outputs report locations
+	OutputPath string
+	Format     string `validate:"omitempty,eq=json|eq=yaml"`
+	ReportPath string
+	// Pipeline stack tags and termination protection for the pipeline
+	// and production stacks
+	Tags                  []string
+	TerminationProtection bool
+	// Environments are the name[:approval[:deployMode]] environments in
+	// promotion order. They default to the registered environments.
+	Environments []string
+	// Branch overrides the branch in the repository URL. Ephemeral
+	// pipelines only deploy the first environment and are deleted by
+	// GCPipelines.
+	Branch    string
+	Ephemeral bool
+	// PullRequests adds a CodeBuild project that builds and tests pull
+	// requests and reports their status to GitHub. It uses the account's
+	// CodeBuild GitHub source credential.
+	PullRequests bool
+	// CommitStatus adds a Lambda function that posts a GitHub commit status
+	// for every stage using the token in the GitHubTokenSecret secret
+	CommitStatus      bool
+	GitHubTokenSecret string
+	// GitHubAPIURL is the GitHub REST API endpoint of the commit status
+	// function, for GitHub Enterprise
+	GitHubAPIURL string `validate:"omitempty,url"`
+	// Notifications publishes pipeline state changes to an SNS topic. The
+	// optional webhook receives them as Slack or Teams messages. The webhook
+	// URL is a credential, so the option is a secret reference that the
+	// function resolves at runtime.
+	Notifications       bool
+	NotificationWebhook string `validate:"omitempty,secretreference"`
+	NotificationFormat  string `validate:"omitempty,eq=slack|eq=teams"`
 }
 
 // AssumePolicyCodeBuildRoleDocument defines common a IAM::Role PolicyDocument
@@ -77,28 +152,153 @@ var AssumePolicyCFNRoleDocument = sparta.ArbitraryJSONObject{
 	},
 }
 
-// Provision is responsible for provisioning/updating the CloudFormation stack
-// that builds out the CI/CD pipeline
-func Provision(provisionOptions *ProvisionOptions) error {
-	logger, loggerErr := sparta.NewLogger("info")
-	if loggerErr != nil {
-		return loggerErr
+// codeBuildVPCStatement allows CodeBuild to manage the ENIs of a project
+// that's attached to a VPC
+var codeBuildVPCStatement = spartaIAM.PolicyStatement{
+	Action: []string{"ec2:CreateNetworkInterface",
+		"ec2:CreateNetworkInterfacePermission",
+		"ec2:DescribeDhcpOptions",
+		"ec2:DescribeNetworkInterfaces",
+		"ec2:DeleteNetworkInterface",
+		"ec2:DescribeSubnets",
+		"ec2:DescribeSecurityGroups",
+		"ec2:DescribeVpcs"},
+	Effect:   "Allow",
+	Resource: gocf.String("*"),
+}
+
+// goModuleEnvironmentVariables returns the plaintext environment variables
+// that configure the Go module proxy and private modules
+func goModuleEnvironmentVariables(provisionOptions *ProvisionOptions) gocf.CodeBuildProjectEnvironmentVariableList {
+	envVars := gocf.CodeBuildProjectEnvironmentVariableList{}
+	plaintextVars := []struct {
+		name  string
+		value string
+	}{
+		{"GOPRIVATE", provisionOptions.GoPrivate},
+		{"GONOSUMDB", provisionOptions.GoNoSumDB},
+		{"GOPROXY", provisionOptions.GoProxy},
 	}
-	awsSession := spartaAWS.NewSession(logger)
-	repoURL, repoURLErr := url.Parse(provisionOptions.GithubRepo)
-	if repoURLErr != nil {
-		return repoURLErr
+	for _, eachVar := range plaintextVars {
+		if eachVar.value != "" {
+			envVars = append(envVars, gocf.CodeBuildProjectEnvironmentVariable{
+				Name:  gocf.String(eachVar.name),
+				Type:  gocf.String("PLAINTEXT"),
+				Value: gocf.String(eachVar.value),
+			})
+		}
 	}
+	return envVars
+}
 
-	// Split the path to get the various parts...If there are more than 3,
-	// the last one is the branchname
-	pathParts := strings.Split(repoURL.Path, "/")
-	ghBranch := "master"
-	if len(pathParts) > 3 {
-		ghBranch = pathParts[len(pathParts)-1]
+// codeBuildEnvironmentVariables returns the environment variables that
+// configure private Go module access in the CodeBuild container. The
+// buildspec.yml consumes the GIT_CREDENTIAL_* values to configure git.
+func codeBuildEnvironmentVariables(provisionOptions *ProvisionOptions) *gocf.CodeBuildProjectEnvironmentVariableList {
+	envVars := goModuleEnvironmentVariables(provisionOptions)
+	if provisionOptions.GitCredentialSecret != "" {
+		credentialVarName := "GIT_CREDENTIAL_TOKEN"
+		if provisionOptions.GitCredentialType == "ssh" {
+			credentialVarName = "GIT_CREDENTIAL_SSH_KEY"
+		}
+		envVars = append(envVars, gocf.CodeBuildProjectEnvironmentVariable{
+			Name:  gocf.String(credentialVarName),
+			Type:  gocf.String("SECRETS_MANAGER"),
+			Value: gocf.String(provisionOptions.GitCredentialSecret),
+		})
+	}
+	if len(envVars) == 0 {
+		return nil
+	}
+	return &envVars
+}
+
+// secretsManagerSecretArn returns the ARN of the Secrets Manager secret for
+// IAM policies. The secret ID is either a name or an ARN, optionally
+// followed by the :json-key:version-stage:version-id suffix that CodeBuild
+// accepts. The ARN of a named secret ends with a random suffix.
+func secretsManagerSecretArn(secretID string) *gocf.StringExpr {
+	if strings.HasPrefix(secretID, "arn:") {
+		// arn:partition:secretsmanager:region:account:secret:name-suffix
+		arnParts := strings.Split(secretID, ":")
+		if len(arnParts) > 7 {
+			arnParts = arnParts[:7]
+		}
+		return gocf.String(strings.Join(arnParts, ":"))
+	}
+	secretName := strings.SplitN(secretID, ":", 2)[0]
+	return gocf.Join("",
+		gocf.String("arn:aws:secretsmanager:"),
+		gocf.Ref("AWS::Region").String(),
+		gocf.String(":"),
+		gocf.Ref("AWS::AccountId").String(),
+		gocf.String(":secret:"+secretName+"-*"))
+}
+
+func stringExprs(values []string) []gocf.Stringable {
+	exprs := make([]gocf.Stringable, len(values))
+	for eachIndex, eachValue := range values {
+		exprs[eachIndex] = gocf.String(eachValue)
+	}
+	return exprs
+}
+
+// newPipelineTemplate returns the CloudFormation template that defines
+// the CI/CD pipeline for the given options
+func newPipelineTemplate(provisionOptions *ProvisionOptions,
+	awsSession *session.Session,
+	logger *logrus.Logger) (*gocf.Template, error) {
+	if provisionOptions.VpcID != "" &&
+		(len(provisionOptions.SubnetIDs) == 0 || len(provisionOptions.SecurityGroupIDs) == 0) {
+		return nil, fmt.Errorf("VPC configuration for %s requires at least one subnet and security group",
+			provisionOptions.VpcID)
+	}
+	if provisionOptions.Ephemeral && provisionOptions.TerminationProtection {
+		return nil, fmt.Errorf("Ephemeral pipelines are deleted by gcPipelines, so they can't enable termination protection")
+	}
+	if provisionOptions.Webhook && provisionOptions.WebhookSecret == "" {
+		return nil, fmt.Errorf("The GitHub webhook requires the secret used to sign its payloads (--webhookSecret)")
+	}
+	if provisionOptions.CommitStatus && provisionOptions.GitHubTokenSecret == "" {
+		return nil, fmt.Errorf("GitHub commit statuses require the Secrets Manager secret with the GitHub token")
+	}
+	ghSource, ghSourceErr := pipelineSource(provisionOptions)
+	if ghSourceErr != nil {
+		return nil, ghSourceErr
+	}
+	ghOwner := ghSource.Owner
+	ghRepo := ghSource.Repo
+	ghBranch := ghSource.Branch
+	names := newNamingPolicy(provisionOptions.PipelineName, ghBranch, provisionOptions.Ephemeral)
+	stackName, stackNameErr := names.pipelineStackName()
+	if stackNameErr != nil {
+		return nil, stackNameErr
+	}
+	// --noop validates the template offline, so it doesn't look up the
+	// deployed pipeline stack
+	var deployedStack *cloudformation.Stack
+	if provisionOptions.Noop {
+		logger.WithFields(logrus.Fields{
+			"StackName": stackName,
+		}).Info("Bypassing the deployed pipeline stack lookup due to --noop flag. Using the derived environment stack names.")
+	} else {
+		var deployedStackErr error
+		deployedStack, deployedStackErr = describeStack(stackName, awsSession)
+		if deployedStackErr != nil {
+			return nil, deployedStackErr
+		}
+	}
+	environments, environmentsErr := pipelineEnvironments(provisionOptions,
+		names,
+		deployedStack,
+		logger)
+	if environmentsErr != nil {
+		return nil, environmentsErr
+	}
+	codeBuildProjectName, codeBuildProjectNameErr := names.codeBuildProjectName()
+	if codeBuildProjectNameErr != nil {
+		return nil, codeBuildProjectNameErr
 	}
-	ghOwner := pathParts[1]
-	ghRepo := pathParts[2]
 
 	logger.WithFields(logrus.Fields{
 		"Owner":  ghOwner,
@@ -142,42 +342,8 @@ func Provision(provisionOptions *ProvisionOptions) error {
 		Description: fmt.Sprintf("The file name of the Sparta template"),
 		Default:     "cloudformation.json",
 	}
-	// Test Stack
-	cfTemplate.Parameters["TestStackName"] = &gocf.Parameter{
-		Type: "String",
-		Description: fmt.Sprintf("Test %s service stack",
-			sparta.OptionsGlobal.ServiceName),
-		Default: fmt.Sprintf("Test-%s-%s",
-			sparta.OptionsGlobal.ServiceName,
-			ghBranch),
-	}
-	cfTemplate.Parameters["TestStackConfig"] = &gocf.Parameter{
-		Type: "String",
-		Description: fmt.Sprintf("The configuration file name for the Test %s stack",
-			sparta.OptionsGlobal.ServiceName),
-		Default: "test.json",
-	}
-	// Production Stack
-	cfTemplate.Parameters["ProdStackName"] = &gocf.Parameter{
-		Type: "String",
-		Description: fmt.Sprintf("Production %s service stack",
-			sparta.OptionsGlobal.ServiceName),
-		Default: fmt.Sprintf("Prod-%s-%s",
-			sparta.OptionsGlobal.ServiceName,
-			ghBranch),
-	}
-	cfTemplate.Parameters["ProdStackConfig"] = &gocf.Parameter{
-		Type: "String",
-		Description: fmt.Sprintf("The configuration file name for the Production %s stack",
-			sparta.OptionsGlobal.ServiceName),
-		Default: "production.json",
-	}
-	cfTemplate.Parameters["ChangeSetName"] = &gocf.Parameter{
-		Type: "String",
-		Description: fmt.Sprintf("A name for the production %s stack ChangeSet",
-			sparta.OptionsGlobal.ServiceName),
-		Default: fmt.Sprintf("UpdatePreview-%s", sparta.OptionsGlobal.ServiceName),
-	}
+	// Environment stacks
+	addEnvironmentParameters(cfTemplate, environments)
 
 	//////////////////////////////////////////////////////////////////////////////
 	/*
@@ -187,25 +353,18 @@ func Provision(provisionOptions *ProvisionOptions) error {
 	 |_|\_\__,_|_|_|_\___/__/
 	*/
 	//////////////////////////////////////////////////////////////////////////////
-	productionStackName := fmt.Sprintf("Prod-%s", sparta.OptionsGlobal.ServiceName)
-	productionChangeSetName := fmt.Sprintf("ProdChangeSet-%s", sparta.OptionsGlobal.ServiceName)
-
+	for _, eachEnvironment := range environments {
+		logger.WithFields(logrus.Fields{
+			"Environment": eachEnvironment.Name,
+			"StackName":   eachEnvironment.StackName,
+			"Approval":    eachEnvironment.Approval,
+			"DeployMode":  eachEnvironment.DeployMode,
+		}).Info("CloudFormation pipeline environment")
+	}
 	logger.WithFields(logrus.Fields{
-		"StackName":     productionStackName,
-		"ChangeSetName": productionChangeSetName,
+		"CodeBuildProject": codeBuildProjectName,
 	}).Info("CloudFormation pipeline information")
 
-	artifactS3BucketResource := sparta.CloudFormationResourceName("S3ArtifactBucket",
-		"S3ArtifactBucket")
-	cfnRoleResource := sparta.CloudFormationResourceName("CloudFormationRole",
-		"CloudFormationRole")
-	codeBuildRoleResource := sparta.CloudFormationResourceName("CodeBuildRole",
-		"CodeBuildRole")
-	codePipelineRoleResource := sparta.CloudFormationResourceName("CodePipelineRole",
-		"CodePipelineRole")
-	codeBuildProjectResource := sparta.CloudFormationResourceName("CodeBuildProject",
-		"CodeBuildProject")
-
 	//////////////////////////////////////////////////////////////////////////////
 	/*
 	  ___ ____  ___         _       _
@@ -272,8 +431,31 @@ func Provision(provisionOptions *ProvisionOptions) error {
 			Resource: gocf.String("*"),
 		},
 	}
+	// CodeBuild needs to manage ENIs when it's attached to a VPC
+	if provisionOptions.VpcID != "" {
+		codebuildRoleStatements = append(codebuildRoleStatements, codeBuildVPCStatement)
+	}
+	// Automated approvals inspect the environment stacks and change sets
+	if hasAutomatedApproval(environments) {
+		codebuildRoleStatements = append(codebuildRoleStatements,
+			spartaIAM.PolicyStatement{
+				Action: []string{"cloudformation:DescribeStacks",
+					"cloudformation:DescribeChangeSet"},
+				Effect:   "Allow",
+				Resource: gocf.String("*"),
+			})
+	}
+	// Only the configured git credential secret is readable
+	if provisionOptions.GitCredentialSecret != "" {
+		codebuildRoleStatements = append(codebuildRoleStatements,
+			spartaIAM.PolicyStatement{
+				Action:   []string{"secretsmanager:GetSecretValue"},
+				Effect:   "Allow",
+				Resource: secretsManagerSecretArn(provisionOptions.GitCredentialSecret),
+			})
+	}
 	codebuildRole := &gocf.IAMRole{
-		Path: gocf.String("/"),
+		Path:                     gocf.String("/"),
 		AssumeRolePolicyDocument: AssumePolicyCodeBuildRoleDocument,
 		Policies: &gocf.IAMRolePolicyList{
 			gocf.IAMRolePolicy{
@@ -335,11 +517,11 @@ func Provision(provisionOptions *ProvisionOptions) error {
 	golangVersionRE := regexp.MustCompile(`go(\d+\.\d+(\.\d+)?)`)
 	matches := golangVersionRE.FindStringSubmatch(runtimeVersion)
 	if len(matches) < 2 {
-		return fmt.Errorf("Unable to determine Go version from runtime: %s", runtimeVersion)
+		return nil, fmt.Errorf("Unable to determine Go version from runtime: %s", runtimeVersion)
 	}
 
 	codeBuildProject := &gocf.CodeBuildProject{
-		Name:             gocf.String(fmt.Sprintf("CodeBuild-%s", sparta.OptionsGlobal.ServiceName)),
+		Name:             gocf.String(codeBuildProjectName),
 		Description:      gocf.String("Builds and deploys the service"),
 		ServiceRole:      gocf.GetAtt(codeBuildRoleResource, "Arn"),
 		TimeoutInMinutes: gocf.Integer(10),
@@ -353,14 +535,59 @@ func Provision(provisionOptions *ProvisionOptions) error {
 			Packaging:     gocf.String("NONE"),
 		},
 		Environment: &gocf.CodeBuildProjectEnvironment{
-			Type:           gocf.String("LINUX_CONTAINER"),
-			Image:          gocf.String(fmt.Sprintf("golang:%s", matches[1])),
-			ComputeType:    gocf.String("BUILD_GENERAL1_SMALL"),
-			PrivilegedMode: gocf.Bool(false),
+			Type:                 gocf.String("LINUX_CONTAINER"),
+			Image:                gocf.String(fmt.Sprintf("golang:%s", matches[1])),
+			ComputeType:          gocf.String("BUILD_GENERAL1_SMALL"),
+			PrivilegedMode:       gocf.Bool(false),
+			EnvironmentVariables: codeBuildEnvironmentVariables(provisionOptions),
 		},
 	}
+	if provisionOptions.VpcID != "" {
+		codeBuildProject.VPCConfig = &gocf.CodeBuildProjectVPCConfig{
+			VPCID:            gocf.String(provisionOptions.VpcID),
+			Subnets:          gocf.StringList(stringExprs(provisionOptions.SubnetIDs)...),
+			SecurityGroupIDs: gocf.StringList(stringExprs(provisionOptions.SecurityGroupIDs)...),
+		}
+	}
 	cfTemplate.AddResource(codeBuildProjectResource, codeBuildProject)
 
+	// Automated approvals run the verification buildspec
+	if hasAutomatedApproval(environments) {
+		verifyProject := &gocf.CodeBuildProject{
+			Description:      gocf.String("Verifies an environment before it's promoted"),
+			ServiceRole:      gocf.GetAtt(codeBuildRoleResource, "Arn"),
+			TimeoutInMinutes: gocf.Integer(10),
+			Source: &gocf.CodeBuildProjectSource{
+				Type:      gocf.String("CODEPIPELINE"),
+				BuildSpec: gocf.String(verifyBuildSpec),
+			},
+			Artifacts: &gocf.CodeBuildProjectArtifacts{
+				Type: gocf.String("CODEPIPELINE"),
+			},
+			Environment: &gocf.CodeBuildProjectEnvironment{
+				Type:                 gocf.String("LINUX_CONTAINER"),
+				Image:                gocf.String(verifyImage),
+				ComputeType:          gocf.String("BUILD_GENERAL1_SMALL"),
+				PrivilegedMode:       gocf.Bool(false),
+				EnvironmentVariables: codeBuildProject.Environment.EnvironmentVariables,
+			},
+			VPCConfig: codeBuildProject.VPCConfig,
+		}
+		cfTemplate.AddResource(verifyProjectResource, verifyProject)
+	}
+
+	if provisionOptions.PullRequests {
+		pullRequestProjectName, pullRequestProjectNameErr := names.pullRequestProjectName()
+		if pullRequestProjectNameErr != nil {
+			return nil, pullRequestProjectNameErr
+		}
+		addPullRequestProject(cfTemplate,
+			provisionOptions,
+			ghSource,
+			pullRequestProjectName,
+			codeBuildProject)
+	}
+
 	//////////////////////////////////////////////////////////////////////////////
 	/*
 	  ___ _           _ _
@@ -384,7 +611,7 @@ func Provision(provisionOptions *ProvisionOptions) error {
 				Name: gocf.String("Source"),
 				Actions: &gocf.CodePipelinePipelineActionDeclarationList{
 					gocf.CodePipelinePipelineActionDeclaration{
-						Name: gocf.String("GitHub"),
+						Name: gocf.String(sourceActionName),
 						ActionTypeID: &gocf.CodePipelinePipelineActionTypeID{
 							Category: gocf.String("Source"),
 							Owner:    gocf.String("ThirdParty"),
@@ -395,7 +622,7 @@ func Provision(provisionOptions *ProvisionOptions) error {
 							"Owner":                ghOwner,
 							"Repo":                 ghRepo,
 							"Branch":               ghBranch,
-							"PollForSourceChanges": "true",
+							"PollForSourceChanges": fmt.Sprintf("%t", !provisionOptions.Webhook),
 							"OAuthToken":           provisionOptions.GithubOAuthToken,
 						},
 						OutputArtifacts: &gocf.CodePipelinePipelineOutputArtifactList{
@@ -413,7 +640,7 @@ func Provision(provisionOptions *ProvisionOptions) error {
 				Name: gocf.String("Build"),
 				Actions: &gocf.CodePipelinePipelineActionDeclarationList{
 					gocf.CodePipelinePipelineActionDeclaration{
-						Name: gocf.String("Build"),
+						Name: gocf.String(buildActionName),
 						InputArtifacts: &gocf.CodePipelinePipelineInputArtifactList{
 							gocf.CodePipelinePipelineInputArtifact{
 								Name: gocf.String("Source"),
@@ -436,164 +663,155 @@ func Provision(provisionOptions *ProvisionOptions) error {
 					},
 				},
 			},
-			//////////////////////////////////////////////////////////////////////////
-			// Test Stage
-			gocf.CodePipelinePipelineStageDeclaration{
-				Name: gocf.String("TestStage"),
-				Actions: &gocf.CodePipelinePipelineActionDeclarationList{
-					gocf.CodePipelinePipelineActionDeclaration{
-						Name: gocf.String("CreateStack"),
-						InputArtifacts: &gocf.CodePipelinePipelineInputArtifactList{
-							gocf.CodePipelinePipelineInputArtifact{
-								Name: gocf.String("Template"),
-							},
-						},
-						ActionTypeID: &gocf.CodePipelinePipelineActionTypeID{
-							Category: gocf.String("Deploy"),
-							Owner:    gocf.String("AWS"),
-							Version:  gocf.String("1"),
-							Provider: gocf.String("CloudFormation"),
-						},
-						Configuration: sparta.ArbitraryJSONObject{
-							"ActionMode":   "CREATE_UPDATE",
-							"Capabilities": "CAPABILITY_IAM",
-							"RoleArn":      gocf.GetAtt(cfnRoleResource, "Arn").String(),
-							"StackName":    gocf.Ref("TestStackName").String(),
-							"TemplateConfiguration": gocf.Join("",
-								gocf.String("Template::"),
-								gocf.Ref("TestStackConfig").String()),
-							"TemplatePath": gocf.Join("",
-								gocf.String("Template::"),
-								gocf.Ref("TemplateFileName").String()),
-						},
-						RunOrder: gocf.Integer(1),
-					},
-					gocf.CodePipelinePipelineActionDeclaration{
-						Name: gocf.String("ApproveTestStack"),
-						ActionTypeID: &gocf.CodePipelinePipelineActionTypeID{
-							Category: gocf.String("Approval"),
-							Owner:    gocf.String("AWS"),
-							Version:  gocf.String("1"),
-							Provider: gocf.String("Manual"),
-						},
-						Configuration: sparta.ArbitraryJSONObject{
-							"CustomData": "Would you like to create a change set to update the production stack",
-						},
-						RunOrder: gocf.Integer(2),
-					},
-				},
+		},
+	}
+	// Environment stages, in promotion order
+	for _, eachEnvironment := range environments {
+		changeSetName, changeSetNameErr := names.environmentChangeSetName(eachEnvironment.Name)
+		if changeSetNameErr != nil {
+			return nil, changeSetNameErr
+		}
+		*codePipeline.Stages = append(*codePipeline.Stages,
+			environmentStage(&eachEnvironment, changeSetName))
+	}
+	cfTemplate.AddResource(codePipelineResource, codePipeline)
+
+	// Push based source changes
+	if provisionOptions.Webhook {
+		cfTemplate.Parameters["WebhookSecret"] = &gocf.Parameter{
+			Type:        "String",
+			Description: "Secret used to sign the GitHub webhook payloads",
+			Default:     provisionOptions.WebhookSecret,
+			NoEcho:      gocf.Bool(true),
+		}
+		webhook := &gocf.CodePipelineWebhook{
+			Authentication: gocf.String("GITHUB_HMAC"),
+			AuthenticationConfiguration: &gocf.CodePipelineWebhookWebhookAuthConfiguration{
+				SecretToken: gocf.Ref("WebhookSecret").String(),
 			},
-			//////////////////////////////////////////////////////////////////////////
-			// Production Stage
-			gocf.CodePipelinePipelineStageDeclaration{
-				Name: gocf.String("ProdStage"),
-				Actions: &gocf.CodePipelinePipelineActionDeclarationList{
-					// Create the production changeset
-					gocf.CodePipelinePipelineActionDeclaration{
-						Name: gocf.String("CreateChangeSet"),
-						InputArtifacts: &gocf.CodePipelinePipelineInputArtifactList{
-							gocf.CodePipelinePipelineInputArtifact{
-								Name: gocf.String("Template"),
-							},
-						},
-						ActionTypeID: &gocf.CodePipelinePipelineActionTypeID{
-							Category: gocf.String("Deploy"),
-							Owner:    gocf.String("AWS"),
-							Version:  gocf.String("1"),
-							Provider: gocf.String("CloudFormation"),
-						},
-						Configuration: sparta.ArbitraryJSONObject{
-							"ActionMode":    "CHANGE_SET_REPLACE",
-							"Capabilities":  "CAPABILITY_IAM",
-							"RoleArn":       gocf.GetAtt(cfnRoleResource, "Arn").String(),
-							"StackName":     gocf.Ref("ProdStackName").String(),
-							"ChangeSetName": productionChangeSetName,
-							"TemplateConfiguration": gocf.Join("",
-								gocf.String("Template::"),
-								gocf.Ref("ProdStackConfig").String()),
-							"TemplatePath": gocf.Join("",
-								gocf.String("Template::"),
-								gocf.Ref("TemplateFileName").String()),
-						},
-						RunOrder: gocf.Integer(1),
-					},
-					// Confirm the promotion
-					gocf.CodePipelinePipelineActionDeclaration{
-						Name: gocf.String("ApproveChangeSet"),
-						ActionTypeID: &gocf.CodePipelinePipelineActionTypeID{
-							Category: gocf.String("Approval"),
-							Owner:    gocf.String("AWS"),
-							Version:  gocf.String("1"),
-							Provider: gocf.String("Manual"),
-						},
-						Configuration: sparta.ArbitraryJSONObject{
-							"CustomData": "Would you like to make these production changes?",
-						},
-						RunOrder: gocf.Integer(2),
-					},
-					// Confirm the promotion
-					gocf.CodePipelinePipelineActionDeclaration{
-						Name: gocf.String("ExecuteChangeSet"),
-						ActionTypeID: &gocf.CodePipelinePipelineActionTypeID{
-							Category: gocf.String("Deploy"),
-							Owner:    gocf.String("AWS"),
-							Version:  gocf.String("1"),
-							Provider: gocf.String("CloudFormation"),
-						},
-						Configuration: sparta.ArbitraryJSONObject{
-							"ActionMode":    "CHANGE_SET_EXECUTE",
-							"ChangeSetName": productionChangeSetName,
-							"RoleArn":       gocf.GetAtt(cfnRoleResource, "Arn").String(),
-							"StackName":     gocf.Ref("ProdStackName").String(),
-						},
-						RunOrder: gocf.Integer(3),
-					},
+			Filters: &gocf.CodePipelineWebhookWebhookFilterRuleList{
+				gocf.CodePipelineWebhookWebhookFilterRule{
+					JSONPath:    gocf.String("$.ref"),
+					MatchEquals: gocf.String("refs/heads/{Branch}"),
 				},
 			},
-		},
+			TargetPipeline:         gocf.Ref(codePipelineResource).String(),
+			TargetAction:           gocf.String(sourceActionName),
+			TargetPipelineVersion:  gocf.GetAtt(codePipelineResource, "Version").Integer(),
+			RegisterWithThirdParty: gocf.Bool(true),
+		}
+		cfTemplate.AddResource(webhookResource, webhook)
+	}
+	if provisionOptions.CommitStatus {
+		addCommitStatusFunction(cfTemplate, provisionOptions, ghSource)
 	}
-	cfTemplate.AddResource("BuildPipeline", codePipeline)
+	if provisionOptions.Notifications {
+		addNotifications(cfTemplate, provisionOptions)
+	}
+	addTemplateOutputs(cfTemplate, provisionOptions.Webhook)
+	return cfTemplate, nil
+}
 
-	// Save the template, post it to S3, wait for things to finish...
-	scratchJSON := filepath.Join("./.sparta", "pipeline.json")
-	tempFile, tempFileErr := os.Create(scratchJSON)
-	if nil != tempFileErr {
-		tempFile.Close()
-		return tempFileErr
+// Provision is responsible for provisioning/updating the CloudFormation stack
+// that builds out the CI/CD pipeline
+func Provision(provisionOptions *ProvisionOptions) error {
+	logger, loggerErr := sparta.NewLogger("info")
+	if loggerErr != nil {
+		return loggerErr
+	}
+	awsSession := spartaAWS.NewSession(logger)
+	stackName, stackNameErr := provisionStackName(provisionOptions)
+	if stackNameErr != nil {
+		return stackNameErr
+	}
+	stackTags, stackTagsErr := parseStackTags(provisionOptions.Tags)
+	if stackTagsErr != nil {
+		return stackTagsErr
+	}
+	if provisionOptions.Ephemeral {
+		ephemeralErr := addEphemeralTags(stackTags, provisionOptions)
+		if ephemeralErr != nil {
+			return ephemeralErr
+		}
+	}
+	cfTemplate, cfTemplateErr := newPipelineTemplate(provisionOptions, awsSession, logger)
+	if cfTemplateErr != nil {
+		return cfTemplateErr
+	}
+
+	if provisionOptions.PullRequests && !provisionOptions.Noop {
+		credentialErr := checkGitHubSourceCredential(awsSession)
+		if credentialErr != nil {
+			return credentialErr
+		}
+	}
+
+	// The template references the packages of the pipeline functions
+	packageErr := packageFunctions(cfTemplate,
+		provisionOptions,
+		!provisionOptions.Noop,
+		awsSession,
+		logger)
+	if packageErr != nil {
+		return packageErr
 	}
 
-	jsonBytes, jsonBytesErr := json.MarshalIndent(cfTemplate, "", " ")
-	if nil != jsonBytesErr {
-		return jsonBytesErr
+	// Save the template, post it to S3, wait for things to finish...
+	templatePath, templatePathErr := writeTemplate(cfTemplate, provisionOptions)
+	if nil != templatePathErr {
+		return templatePathErr
 	}
-	_, writeErr := tempFile.Write(jsonBytes)
-	if nil != writeErr {
-		return writeErr
+	if templateOutputPath(provisionOptions) == stdoutPath {
+		// The template was written to a temporary file for the upload
+		defer os.Remove(templatePath)
 	}
-	tempFile.Close()
 
 	// Tell the user what to put as the `buildspec.yml` in the root project directory
 	if provisionOptions.Noop {
 		// Just log it...
 		logger.WithFields(logrus.Fields{
-			"TemplatePath": tempFile.Name(),
+			"TemplatePath": templatePath,
 		}).Info("Bypassing upload due to --noop flag")
+		issues, issuesErr := validateTemplate(cfTemplate)
+		if issuesErr != nil {
+			return issuesErr
+		}
+		return logValidationIssues(issues, logger)
 	} else {
-		uploadLocation, uploadURLErr := spartaS3.UploadLocalFileToS3(tempFile.Name(),
+		uploadLocation, uploadURLErr := spartaS3.UploadLocalFileToS3(templatePath,
 			awsSession,
 			provisionOptions.S3Bucket,
-			fmt.Sprintf("%s-codepipelineTemplate.json", sparta.OptionsGlobal.ServiceName),
+			fmt.Sprintf("%s-codepipelineTemplate.%s",
+				sparta.OptionsGlobal.ServiceName,
+				templateFormat(provisionOptions)),
 			logger)
 		if nil != uploadURLErr {
 			return uploadURLErr
 		}
-		pipelineStackName := fmt.Sprintf("%s-%s",
-			sparta.OptionsGlobal.ServiceName,
-			provisionOptions.PipelineName)
-		stackResult, stackResultErr := spartaCF.ConvergeStackState(pipelineStackName,
+		if provisionOptions.Plan {
+			plannedStack, plannedStackErr := planStack(stackName,
+				uploadLocation,
+				stackTags,
+				provisionOptions.Yes,
+				awsSession,
+				os.Stdout,
+				logger)
+			if nil != plannedStackErr || nil == plannedStack {
+				return plannedStackErr
+			}
+			protectErr := protectPipelineStack(plannedStack,
+				provisionOptions.TerminationProtection,
+				awsSession,
+				logger)
+			if nil != protectErr {
+				return protectErr
+			}
+			return reportStackOutputs(plannedStack, provisionOptions.ReportPath, logger)
+		}
+		stackResult, stackResultErr := spartaCF.ConvergeStackState(stackName,
 			cfTemplate,
 			uploadLocation,
-			nil,
+			stackTags,
 			time.Now(),
 			awsSession,
 			convergeDivider,
@@ -604,6 +822,17 @@ func Provision(provisionOptions *ProvisionOptions) error {
 		logger.WithFields(logrus.Fields{
 			"StackId": *stackResult.StackId,
 		}).Info("Pipeline provisioned")
+		protectErr := protectPipelineStack(stackResult,
+			provisionOptions.TerminationProtection,
+			awsSession,
+			logger)
+		if nil != protectErr {
+			return protectErr
+		}
+		reportErr := reportStackOutputs(stackResult, provisionOptions.ReportPath, logger)
+		if nil != reportErr {
+			return reportErr
+		}
 	}
 	// Great we have a pipeline!
 	return nil
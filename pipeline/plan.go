@@ -0,0 +1,213 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	survey "github.com/AlecAivazis/survey"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/sirupsen/logrus"
+)
+
+// protectedResources are the pipeline stack resources whose replacement
+// loses state or breaks in-flight executions
+var protectedResources = map[string]string{
+	artifactS3BucketResource: "artifact bucket",
+	codeBuildProjectResource: "CodeBuild project",
+	codeBuildRoleResource:    "CodeBuild role",
+	codePipelineRoleResource: "CodePipeline role",
+	cfnRoleResource:          "CloudFormation role",
+	codePipelineResource:     "pipeline",
+}
+
+// writeChangeSet writes the resource changes in the change set
+func writeChangeSet(changeSetOutput *cloudformation.DescribeChangeSetOutput,
+	writer io.Writer) error {
+	tabWriter := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tabWriter, "ChangeSet %s (%s): %s\n\n",
+		aws.StringValue(changeSetOutput.ChangeSetName),
+		aws.StringValue(changeSetOutput.StackName),
+		aws.StringValue(changeSetOutput.Status))
+	fmt.Fprintf(tabWriter, "ACTION\tLOGICAL ID\tTYPE\tREPLACEMENT\n")
+	for _, eachChange := range changeSetOutput.Changes {
+		if eachChange.ResourceChange == nil {
+			continue
+		}
+		fmt.Fprintf(tabWriter, "%s\t%s\t%s\t%s\n",
+			aws.StringValue(eachChange.ResourceChange.Action),
+			aws.StringValue(eachChange.ResourceChange.LogicalResourceId),
+			aws.StringValue(eachChange.ResourceChange.ResourceType),
+			aws.StringValue(eachChange.ResourceChange.Replacement))
+	}
+	fmt.Fprintln(tabWriter)
+	return tabWriter.Flush()
+}
+
+// replacementWarnings returns a warning for every protected resource that
+// the change set will, or may, replace or remove
+func replacementWarnings(changeSetOutput *cloudformation.DescribeChangeSetOutput) []string {
+	warnings := make([]string, 0)
+	for _, eachChange := range changeSetOutput.Changes {
+		resourceChange := eachChange.ResourceChange
+		if resourceChange == nil {
+			continue
+		}
+		logicalID := aws.StringValue(resourceChange.LogicalResourceId)
+		description, isProtected := protectedResources[logicalID]
+		if !isProtected {
+			continue
+		}
+		switch {
+		case aws.StringValue(resourceChange.Action) == cloudformation.ChangeActionRemove:
+			warnings = append(warnings, fmt.Sprintf("The %s (%s) will be removed",
+				description,
+				logicalID))
+		case aws.StringValue(resourceChange.Replacement) == cloudformation.ReplacementTrue:
+			warnings = append(warnings, fmt.Sprintf("The %s (%s) will be replaced",
+				description,
+				logicalID))
+		case aws.StringValue(resourceChange.Replacement) == cloudformation.ReplacementConditional:
+			warnings = append(warnings, fmt.Sprintf("The %s (%s) may be replaced",
+				description,
+				logicalID))
+		}
+	}
+	return warnings
+}
+
+// confirmChangeSet asks the user whether the change set should be executed
+func confirmChangeSet(stackName string, autoConfirm bool) (bool, error) {
+	if autoConfirm {
+		return true, nil
+	}
+	confirmed := false
+	prompt := &survey.Confirm{
+		Message: fmt.Sprintf("Execute these changes to stack %s?", stackName),
+	}
+	promptErr := survey.AskOne(prompt, &confirmed, nil)
+	return confirmed, promptErr
+}
+
+// planChangeSetName returns the <stack>-plan-<unix> change set name,
+// truncating the stack name so that it fits the change set name limit
+func planChangeSetName(stackName string, now time.Time) string {
+	suffix := fmt.Sprintf("-plan-%d", now.Unix())
+	if len(stackName)+len(suffix) > maxChangeSetNameLength {
+		stackName = stackName[:maxChangeSetNameLength-len(suffix)]
+	}
+	return stackName + suffix
+}
+
+// planStack creates a change set for the pipeline stack, writes it
+// and executes it once confirmed. The returned stack is nil if the
+// change set was not executed.
+func planStack(stackName string,
+	templateURL string,
+	tags map[string]string,
+	autoConfirm bool,
+	awsSession *session.Session,
+	writer io.Writer,
+	logger *logrus.Logger) (*cloudformation.Stack, error) {
+	cfSvc := cloudformation.New(awsSession)
+	existingStack, existingStackErr := describeStack(stackName, awsSession)
+	if existingStackErr != nil {
+		return nil, existingStackErr
+	}
+	// A stack whose first change set was never executed stays in
+	// REVIEW_IN_PROGRESS and still needs a CREATE change set
+	changeSetType := cloudformation.ChangeSetTypeCreate
+	if existingStack != nil &&
+		aws.StringValue(existingStack.StackStatus) != cloudformation.StackStatusReviewInProgress {
+		changeSetType = cloudformation.ChangeSetTypeUpdate
+	}
+	changeSetName := planChangeSetName(stackName, time.Now())
+	_, createErr := cfSvc.CreateChangeSet(&cloudformation.CreateChangeSetInput{
+		StackName:     aws.String(stackName),
+		ChangeSetName: aws.String(changeSetName),
+		ChangeSetType: aws.String(changeSetType),
+		TemplateURL:   aws.String(templateURL),
+		Capabilities:  aws.StringSlice([]string{cloudformation.CapabilityCapabilityIam}),
+		Tags:          cloudFormationTags(tags),
+	})
+	if createErr != nil {
+		return nil, createErr
+	}
+	describeInput := &cloudformation.DescribeChangeSetInput{
+		StackName:     aws.String(stackName),
+		ChangeSetName: aws.String(changeSetName),
+	}
+	deleteChangeSet := func() error {
+		_, deleteErr := cfSvc.DeleteChangeSet(&cloudformation.DeleteChangeSetInput{
+			StackName:     aws.String(stackName),
+			ChangeSetName: aws.String(changeSetName),
+		})
+		return deleteErr
+	}
+	waitErr := cfSvc.WaitUntilChangeSetCreateComplete(describeInput)
+	changeSetOutput, changeSetErr := cfSvc.DescribeChangeSet(describeInput)
+	if changeSetErr != nil {
+		return nil, changeSetErr
+	}
+	if aws.StringValue(changeSetOutput.Status) == cloudformation.ChangeSetStatusFailed {
+		reason := aws.StringValue(changeSetOutput.StatusReason)
+		if strings.Contains(reason, "didn't contain changes") ||
+			strings.Contains(reason, "No updates are to be performed") {
+			logger.WithFields(logrus.Fields{
+				"StackName": stackName,
+			}).Info("No changes to the pipeline stack")
+			return nil, deleteChangeSet()
+		}
+		return nil, fmt.Errorf("Failed to create change set for stack %s: %s", stackName, reason)
+	}
+	if waitErr != nil {
+		return nil, waitErr
+	}
+
+	writeErr := writeChangeSet(changeSetOutput, writer)
+	if writeErr != nil {
+		return nil, writeErr
+	}
+	for _, eachWarning := range replacementWarnings(changeSetOutput) {
+		logger.Warn(eachWarning)
+	}
+	confirmed, confirmErr := confirmChangeSet(stackName, autoConfirm)
+	if confirmErr != nil {
+		return nil, confirmErr
+	}
+	if !confirmed {
+		logger.WithFields(logrus.Fields{
+			"StackName":     stackName,
+			"ChangeSetName": changeSetName,
+		}).Info("Change set not executed")
+		return nil, deleteChangeSet()
+	}
+	_, executeErr := cfSvc.ExecuteChangeSet(&cloudformation.ExecuteChangeSetInput{
+		StackName:     aws.String(stackName),
+		ChangeSetName: aws.String(changeSetName),
+	})
+	if executeErr != nil {
+		return nil, executeErr
+	}
+	logger.WithFields(logrus.Fields{
+		"StackName":     stackName,
+		"ChangeSetName": changeSetName,
+	}).Info("Executing change set")
+	stackInput := &cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackName),
+	}
+	var completeErr error
+	if changeSetType == cloudformation.ChangeSetTypeCreate {
+		completeErr = cfSvc.WaitUntilStackCreateComplete(stackInput)
+	} else {
+		completeErr = cfSvc.WaitUntilStackUpdateComplete(stackInput)
+	}
+	if completeErr != nil {
+		return nil, completeErr
+	}
+	return describeStack(stackName, awsSession)
+}
@@ -0,0 +1,38 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlanChangeSetName(t *testing.T) {
+	now := time.Unix(1517486400, 0)
+	tests := []struct {
+		name      string
+		stackName string
+		expected  string
+	}{
+		{
+			name:      "short stack name",
+			stackName: "MyService-pipeline",
+			expected:  "MyService-pipeline-plan-1517486400",
+		},
+		{
+			name:      "truncated stack name",
+			stackName: strings.Repeat("s", maxStackNameLength),
+			expected:  strings.Repeat("s", maxChangeSetNameLength-len("-plan-1517486400")) + "-plan-1517486400",
+		},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.name, func(t *testing.T) {
+			changeSetName := planChangeSetName(eachTest.stackName, now)
+			if changeSetName != eachTest.expected {
+				t.Errorf("Change set name %q, expected %q", changeSetName, eachTest.expected)
+			}
+			if len(changeSetName) > maxChangeSetNameLength {
+				t.Errorf("Change set name has %d characters", len(changeSetName))
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/mweagle/Sparta"
+	"github.com/sirupsen/logrus"
+)
+
+// parseStackTags converts the key=value --tag values into a map
+func parseStackTags(tagValues []string) (map[string]string, error) {
+	tags := make(map[string]string, len(tagValues))
+	for _, eachValue := range tagValues {
+		tagParts := strings.SplitN(eachValue, "=", 2)
+		if len(tagParts) != 2 || tagParts[0] == "" {
+			return nil, fmt.Errorf("Invalid tag %s, tags must be in key=value form", eachValue)
+		}
+		tags[tagParts[0]] = tagParts[1]
+	}
+	return tags, nil
+}
+
+// cloudFormationTags converts the tag map into CloudFormation stack tags.
+// CloudFormation propagates stack tags to every resource that supports them.
+func cloudFormationTags(tags map[string]string) []*cloudformation.Tag {
+	cfTags := make([]*cloudformation.Tag, 0, len(tags))
+	for eachKey, eachValue := range tags {
+		cfTags = append(cfTags, &cloudformation.Tag{
+			Key:   aws.String(eachKey),
+			Value: aws.String(eachValue),
+		})
+	}
+	return cfTags
+}
+
+// pipelineStackPolicy returns the default stack policy, which prevents the
+// artifact bucket and the pipeline from being replaced or deleted by a
+// stack update
+func pipelineStackPolicy() (string, error) {
+	policy := sparta.ArbitraryJSONObject{
+		"Statement": []sparta.ArbitraryJSONObject{
+			{
+				"Effect":    "Allow",
+				"Action":    "Update:*",
+				"Principal": "*",
+				"Resource":  "*",
+			},
+			{
+				"Effect":    "Deny",
+				"Action":    []string{"Update:Replace", "Update:Delete"},
+				"Principal": "*",
+				"Resource": []string{
+					fmt.Sprintf("LogicalResourceId/%s", artifactS3BucketResource),
+					fmt.Sprintf("LogicalResourceId/%s", codePipelineResource),
+				},
+			},
+		},
+	}
+	policyBytes, policyBytesErr := json.Marshal(policy)
+	if policyBytesErr != nil {
+		return "", policyBytesErr
+	}
+	return string(policyBytes), nil
+}
+
+// setTerminationProtection enables or disables termination protection
+// for the named stack
+func setTerminationProtection(stackName string,
+	enabled bool,
+	awsSession *session.Session,
+	logger *logrus.Logger) error {
+	cfSvc := cloudformation.New(awsSession)
+	_, updateErr := cfSvc.UpdateTerminationProtection(&cloudformation.UpdateTerminationProtectionInput{
+		StackName:                   aws.String(stackName),
+		EnableTerminationProtection: aws.Bool(enabled),
+	})
+	if updateErr != nil {
+		return updateErr
+	}
+	logger.WithFields(logrus.Fields{
+		"StackName": stackName,
+		"Enabled":   enabled,
+	}).Info("Stack termination protection")
+	return nil
+}
+
+// protectPipelineStack applies the default stack policy to the pipeline
+// stack and, if requested, enables termination protection for the pipeline
+// and production stacks. Ephemeral pipelines can't request termination
+// protection because gcPipelines deletes their stacks.
+func protectPipelineStack(stack *cloudformation.Stack,
+	terminationProtection bool,
+	awsSession *session.Session,
+	logger *logrus.Logger) error {
+	stackPolicy, stackPolicyErr := pipelineStackPolicy()
+	if stackPolicyErr != nil {
+		return stackPolicyErr
+	}
+	cfSvc := cloudformation.New(awsSession)
+	_, setPolicyErr := cfSvc.SetStackPolicy(&cloudformation.SetStackPolicyInput{
+		StackName:       stack.StackId,
+		StackPolicyBody: aws.String(stackPolicy),
+	})
+	if setPolicyErr != nil {
+		return setPolicyErr
+	}
+	if !terminationProtection {
+		return nil
+	}
+	protectErr := setTerminationProtection(aws.StringValue(stack.StackName),
+		true,
+		awsSession,
+		logger)
+	if protectErr != nil {
+		return protectErr
+	}
+	// The production stack is created by the pipeline, so it can only be
+	// protected once the pipeline has deployed it
+	prodStackName := productionStackName(stack)
+	if prodStackName == "" {
+		logger.WithFields(logrus.Fields{
+			"StackName": aws.StringValue(stack.StackName),
+		}).Warn("Pipeline doesn't deploy the production environment. Only the pipeline stack is protected")
+		return nil
+	}
+	prodStack, prodStackErr := describeStack(prodStackName, awsSession)
+	if prodStackErr != nil {
+		return prodStackErr
+	}
+	if prodStack == nil {
+		logger.WithFields(logrus.Fields{
+			"StackName": prodStackName,
+		}).Warn("Production stack does not exist yet. Provision again after the first release to protect it")
+		return nil
+	}
+	return setTerminationProtection(prodStackName, true, awsSession, logger)
+}
@@ -0,0 +1,152 @@
+package pipeline
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/mweagle/Sparta"
+	spartaIAM "github.com/mweagle/Sparta/aws/iam"
+	gocf "github.com/mweagle/go-cloudformation"
+)
+
+// Logical resource names of the optional pull request build
+var (
+	pullRequestProjectResource = sparta.CloudFormationResourceName("PullRequestProject",
+		"PullRequestProject")
+	pullRequestRoleResource = sparta.CloudFormationResourceName("PullRequestRole",
+		"PullRequestRole")
+)
+
+// pullRequestBuildSpec is the buildspec that builds and tests pull requests
+// without deploying them
+const pullRequestBuildSpec = "buildspec-pr.yml"
+
+// pullRequestEvents are the GitHub webhook events that start a pull
+// request build
+const pullRequestEvents = "PULL_REQUEST_CREATED,PULL_REQUEST_UPDATED,PULL_REQUEST_REOPENED"
+
+// checkGitHubSourceCredential returns an error if the account and region
+// don't have the GitHub source credential that CodeBuild needs to create
+// the pull request webhook and report the build status. There is one
+// GitHub credential per account and region, so it's managed outside the
+// pipeline stack.
+func checkGitHubSourceCredential(awsSession *session.Session) error {
+	credentialsOutput, credentialsErr := codebuild.New(awsSession).ListSourceCredentials(&codebuild.ListSourceCredentialsInput{})
+	if credentialsErr != nil {
+		return credentialsErr
+	}
+	for _, eachCredential := range credentialsOutput.SourceCredentialsInfos {
+		if aws.StringValue(eachCredential.ServerType) == codebuild.ServerTypeGithub {
+			return nil
+		}
+	}
+	return fmt.Errorf("Pull request builds require a CodeBuild GitHub source credential. Import one with: aws codebuild import-source-credentials --server-type GITHUB --auth-type PERSONAL_ACCESS_TOKEN --token <token>")
+}
+
+// pullRequestRoleStatements are the only privileges of the pull request
+// builds, which run untrusted code: writing their logs and test reports
+func pullRequestRoleStatements(provisionOptions *ProvisionOptions) []spartaIAM.PolicyStatement {
+	statements := []spartaIAM.PolicyStatement{
+		spartaIAM.PolicyStatement{
+			Action: []string{"logs:CreateLogGroup",
+				"logs:CreateLogStream",
+				"logs:PutLogEvents"},
+			Effect:   "Allow",
+			Resource: gocf.String("*"),
+		},
+		spartaIAM.PolicyStatement{
+			Action: []string{"codebuild:CreateReportGroup",
+				"codebuild:CreateReport",
+				"codebuild:UpdateReport",
+				"codebuild:BatchPutTestCases",
+				"codebuild:BatchPutCodeCoverages"},
+			Effect:   "Allow",
+			Resource: gocf.String("*"),
+		},
+	}
+	if provisionOptions.VpcID != "" {
+		statements = append(statements, codeBuildVPCStatement)
+	}
+	return statements
+}
+
+// addPullRequestProject adds a CodeBuild project that builds and tests
+// every pull request to the repository and reports the build status to
+// the pull request. Pull requests, including those from forks, run
+// untrusted code, so the project has its own role and no secrets. It
+// shares the image and VPC configuration of the pipeline's CodeBuild
+// project.
+func addPullRequestProject(cfTemplate *gocf.Template,
+	provisionOptions *ProvisionOptions,
+	source *gitHubRepo,
+	projectName string,
+	pipelineProject *gocf.CodeBuildProject) {
+
+	pullRequestRole := &gocf.IAMRole{
+		Path:                     gocf.String("/"),
+		AssumeRolePolicyDocument: AssumePolicyCodeBuildRoleDocument,
+		Policies: &gocf.IAMRolePolicyList{
+			gocf.IAMRolePolicy{
+				PolicyName: gocf.String("PullRequestRole"),
+				PolicyDocument: sparta.ArbitraryJSONObject{
+					"Version":   "2012-10-17",
+					"Statement": pullRequestRoleStatements(provisionOptions),
+				},
+			},
+		},
+	}
+	cfTemplate.AddResource(pullRequestRoleResource, pullRequestRole)
+
+	// The buildspec provisions with --noop, which needs a bucket name but
+	// doesn't write to it
+	envVars := goModuleEnvironmentVariables(provisionOptions)
+	envVars = append(envVars, gocf.CodeBuildProjectEnvironmentVariable{
+		Name:  gocf.String("S3_BUCKET"),
+		Type:  gocf.String("PLAINTEXT"),
+		Value: gocf.String(provisionOptions.S3Bucket),
+	})
+	pullRequestProject := &gocf.CodeBuildProject{
+		Name:             gocf.String(projectName),
+		Description:      gocf.String("Builds and tests pull requests"),
+		ServiceRole:      gocf.GetAtt(pullRequestRoleResource, "Arn"),
+		TimeoutInMinutes: gocf.Integer(10),
+		Source: &gocf.CodeBuildProjectSource{
+			Type: gocf.String("GITHUB"),
+			Location: gocf.String(fmt.Sprintf("https://github.com/%s/%s.git",
+				source.Owner,
+				source.Repo)),
+			BuildSpec:         gocf.String(pullRequestBuildSpec),
+			ReportBuildStatus: gocf.Bool(true),
+		},
+		Artifacts: &gocf.CodeBuildProjectArtifacts{
+			Type: gocf.String("NO_ARTIFACTS"),
+		},
+		Environment: &gocf.CodeBuildProjectEnvironment{
+			Type:                 pipelineProject.Environment.Type,
+			Image:                pipelineProject.Environment.Image,
+			ComputeType:          pipelineProject.Environment.ComputeType,
+			PrivilegedMode:       gocf.Bool(false),
+			EnvironmentVariables: &envVars,
+		},
+		VPCConfig: pipelineProject.VPCConfig,
+		Triggers: &gocf.CodeBuildProjectProjectTriggers{
+			Webhook: gocf.Bool(true),
+			FilterGroups: [][]sparta.ArbitraryJSONObject{
+				{
+					{
+						"Type":    "EVENT",
+						"Pattern": pullRequestEvents,
+					},
+				},
+			},
+		},
+	}
+	cfTemplate.AddResource(pullRequestProjectResource, pullRequestProject)
+
+	cfTemplate.Outputs["PullRequestProjectName"] = &gocf.Output{
+		Description: "Pull request CodeBuild project name",
+		Value:       gocf.Ref(pullRequestProjectResource),
+	}
+}
@@ -0,0 +1,48 @@
+package pipeline
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+// stackResourcePhysicalID returns the physical ID of the logical resource
+// in the pipeline stack for the named pipeline
+func stackResourcePhysicalID(pipelineName string,
+	logicalResourceName string,
+	awsSession *session.Session) (string, error) {
+	return stackResourceID(pipelineStackName(pipelineName),
+		logicalResourceName,
+		awsSession)
+}
+
+// stackResourceID returns the physical ID of the logical resource in the
+// named stack
+func stackResourceID(stackName string,
+	logicalResourceName string,
+	awsSession *session.Session) (string, error) {
+	cfSvc := cloudformation.New(awsSession)
+	resourceOutput, resourceErr := cfSvc.DescribeStackResource(&cloudformation.DescribeStackResourceInput{
+		StackName:         aws.String(stackName),
+		LogicalResourceId: aws.String(logicalResourceName),
+	})
+	if resourceErr != nil {
+		return "", resourceErr
+	}
+	physicalID := aws.StringValue(resourceOutput.StackResourceDetail.PhysicalResourceId)
+	if physicalID == "" {
+		return "", fmt.Errorf("Resource %s in stack %s has not been provisioned",
+			logicalResourceName,
+			stackName)
+	}
+	return physicalID, nil
+}
+
+// resolvePipelineName returns the CodePipeline name for the pipeline
+// provisioned by Provision with the same pipeline name
+func resolvePipelineName(pipelineName string,
+	awsSession *session.Session) (string, error) {
+	return stackResourcePhysicalID(pipelineName, codePipelineResource, awsSession)
+}
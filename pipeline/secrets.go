@@ -0,0 +1,249 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/mweagle/Sparta"
+	spartaAWS "github.com/mweagle/Sparta/aws"
+	spartaIAM "github.com/mweagle/Sparta/aws/iam"
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/sirupsen/logrus"
+)
+
+// Variables that RegisterEnvironment adds to every environment
+const (
+	// environmentNameVariable is the name of the environment the function
+	// runs in
+	environmentNameVariable = "PIPELINE_ENVIRONMENT"
+	// secretKeysVariable lists the comma separated keys of the
+	// environment's secret references
+	secretKeysVariable = "PIPELINE_SECRET_KEYS"
+)
+
+// Secret reference prefixes
+const (
+	ssmReferencePrefix            = "ssm:"
+	secretsManagerReferencePrefix = "secretsmanager:"
+)
+
+// secretReference is an ssm:/path or secretsmanager:id[#key] environment
+// value
+type secretReference struct {
+	service string
+	// name is the SSM parameter name or the secret name or ARN
+	name string
+	// jsonKey is the optional key in a JSON secret
+	jsonKey string
+}
+
+// parseSecretReference returns the reference in the environment value,
+// or nil if it isn't a reference
+func parseSecretReference(value string) *secretReference {
+	switch {
+	case strings.HasPrefix(value, ssmReferencePrefix) && len(value) > len(ssmReferencePrefix):
+		return &secretReference{
+			service: "ssm",
+			name:    strings.TrimPrefix(value, ssmReferencePrefix),
+		}
+	case strings.HasPrefix(value, secretsManagerReferencePrefix) && len(value) > len(secretsManagerReferencePrefix):
+		reference := &secretReference{
+			service: "secretsmanager",
+			name:    strings.TrimPrefix(value, secretsManagerReferencePrefix),
+		}
+		if keyIndex := strings.LastIndex(reference.name, "#"); keyIndex > 0 {
+			reference.jsonKey = reference.name[keyIndex+1:]
+			reference.name = reference.name[:keyIndex]
+		}
+		return reference
+	default:
+		return nil
+	}
+}
+
+// privilege returns the IAM actions and resource needed to read the
+// referenced value
+func (reference *secretReference) privilege() ([]string, *gocf.StringExpr) {
+	if reference.service == "ssm" {
+		return []string{"ssm:GetParameter", "ssm:GetParameters"},
+			gocf.Join("",
+				gocf.String("arn:aws:ssm:"),
+				gocf.Ref("AWS::Region").String(),
+				gocf.String(":"),
+				gocf.Ref("AWS::AccountId").String(),
+				gocf.String(":parameter/"+strings.TrimPrefix(reference.name, "/")))
+	}
+	return []string{"secretsmanager:GetSecretValue"}, secretsManagerSecretArn(reference.name)
+}
+
+// environmentSecretKeys returns the sorted keys of the environment's
+// variables that are secret references
+func environmentSecretKeys(environment *Environment) []string {
+	secretKeys := make([]string, 0)
+	for eachKey, eachValue := range environment.Variables {
+		if parseSecretReference(eachValue) != nil {
+			secretKeys = append(secretKeys, eachKey)
+		}
+	}
+	sort.Strings(secretKeys)
+	return secretKeys
+}
+
+// environmentVariables returns the variables that Sparta writes to the
+// environment's template configuration file: the environment's own
+// variables, its name and the keys of its secret references
+func environmentVariables(environment *Environment) map[string]string {
+	variables := make(map[string]string, len(environment.Variables)+2)
+	for eachKey, eachValue := range environment.Variables {
+		variables[eachKey] = eachValue
+	}
+	variables[environmentNameVariable] = environment.Name
+	variables[secretKeysVariable] = strings.Join(environmentSecretKeys(environment), ",")
+	return variables
+}
+
+// environmentSecretStatements returns the statements that allow the
+// function to read the secrets referenced by the environment
+func environmentSecretStatements(environment *Environment) []spartaIAM.PolicyStatement {
+	statements := make([]spartaIAM.PolicyStatement, 0)
+	for _, eachKey := range environmentSecretKeys(environment) {
+		actions, resource := parseSecretReference(environment.Variables[eachKey]).privilege()
+		statements = append(statements, spartaIAM.PolicyStatement{
+			Action:   actions,
+			Effect:   "Allow",
+			Resource: resource,
+		})
+	}
+	return statements
+}
+
+// SecretPolicyDecorator is a sparta.TemplateDecorator that grants the
+// function's role read access to the secrets referenced by the registered
+// environments. Every environment deploys the same template, so each
+// environment's policy is only created in the stack whose
+// PIPELINE_ENVIRONMENT parameter names that environment.
+func SecretPolicyDecorator(serviceName string,
+	lambdaResourceName string,
+	lambdaResource gocf.LambdaFunction,
+	resourceMetadata map[string]interface{},
+	S3Bucket string,
+	S3Key string,
+	buildID string,
+	template *gocf.Template,
+	context map[string]interface{},
+	logger *logrus.Logger) error {
+	if lambdaResource.Role == nil {
+		return fmt.Errorf("Function %s doesn't have a role", lambdaResourceName)
+	}
+	roleAttr, roleAttrOk := lambdaResource.Role.Func.(gocf.GetAttFunc)
+	if !roleAttrOk {
+		return fmt.Errorf("Function %s role isn't defined in the template", lambdaResourceName)
+	}
+	if template.Conditions == nil {
+		template.Conditions = make(map[string]interface{})
+	}
+	for _, eachEnvironment := range registeredEnvironments {
+		statements := environmentSecretStatements(&eachEnvironment)
+		if len(statements) == 0 {
+			continue
+		}
+		conditionName := sparta.CloudFormationResourceName("SecretsCondition",
+			lambdaResourceName,
+			eachEnvironment.Name)
+		template.Conditions[conditionName] = sparta.ArbitraryJSONObject{
+			"Fn::Equals": []interface{}{
+				gocf.Ref(environmentNameVariable),
+				eachEnvironment.Name,
+			},
+		}
+		policy := &gocf.IAMPolicy{
+			PolicyName: gocf.String(environmentTitle(eachEnvironment.Name) + "Secrets"),
+			PolicyDocument: sparta.ArbitraryJSONObject{
+				"Version":   "2012-10-17",
+				"Statement": statements,
+			},
+			Roles: gocf.StringList(gocf.Ref(roleAttr.Resource)),
+		}
+		policyResource := template.AddResource(sparta.CloudFormationResourceName("SecretsPolicy",
+			lambdaResourceName,
+			eachEnvironment.Name),
+			policy)
+		policyResource.Condition = conditionName
+		logger.WithFields(logrus.Fields{
+			"Function":    lambdaResourceName,
+			"Environment": eachEnvironment.Name,
+			"Secrets":     len(statements),
+		}).Debug("Granting read access to the environment's secrets")
+	}
+	return nil
+}
+
+// resolveSecretReference returns the referenced value
+func resolveSecretReference(reference *secretReference,
+	awsSession *session.Session) (string, error) {
+	if reference.service == "ssm" {
+		parameterOutput, parameterErr := ssm.New(awsSession).GetParameter(&ssm.GetParameterInput{
+			Name:           aws.String(reference.name),
+			WithDecryption: aws.Bool(true),
+		})
+		if parameterErr != nil {
+			return "", parameterErr
+		}
+		return aws.StringValue(parameterOutput.Parameter.Value), nil
+	}
+	secretValue, secretErr := secretString(reference.name, awsSession)
+	if secretErr != nil || reference.jsonKey == "" {
+		return secretValue, secretErr
+	}
+	secretFields := make(map[string]interface{})
+	unmarshalErr := json.Unmarshal([]byte(secretValue), &secretFields)
+	if unmarshalErr != nil {
+		return "", fmt.Errorf("Secret %s is not a JSON object: %s", reference.name, unmarshalErr)
+	}
+	fieldValue, fieldValueOk := secretFields[reference.jsonKey]
+	if !fieldValueOk {
+		return "", fmt.Errorf("Secret %s has no %s key", reference.name, reference.jsonKey)
+	}
+	return fmt.Sprintf("%v", fieldValue), nil
+}
+
+// ResolveSecretEnvironment replaces the environment variables listed in
+// PIPELINE_SECRET_KEYS, which reference an SSM parameter or a Secrets
+// Manager secret, with the referenced values. Call it once at Lambda cold
+// start.
+func ResolveSecretEnvironment() error {
+	secretKeys := os.Getenv(secretKeysVariable)
+	if secretKeys == "" {
+		return nil
+	}
+	logger, loggerErr := sparta.NewLogger("info")
+	if loggerErr != nil {
+		return loggerErr
+	}
+	awsSession := spartaAWS.NewSession(logger)
+	for _, eachKey := range strings.Split(secretKeys, ",") {
+		reference := parseSecretReference(os.Getenv(eachKey))
+		if reference == nil {
+			return fmt.Errorf("Variable %s is not an ssm: or secretsmanager: reference", eachKey)
+		}
+		resolved, resolvedErr := resolveSecretReference(reference, awsSession)
+		if resolvedErr != nil {
+			return fmt.Errorf("Failed to resolve %s: %s", eachKey, resolvedErr)
+		}
+		setErr := os.Setenv(eachKey, resolved)
+		if setErr != nil {
+			return setErr
+		}
+		logger.WithFields(logrus.Fields{
+			"Variable": eachKey,
+			"Service":  reference.service,
+		}).Info("Resolved secret environment variable")
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSecretReference(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected *secretReference
+	}{
+		{"ssm:/prod/db/password", &secretReference{service: "ssm", name: "/prod/db/password"}},
+		{"secretsmanager:prod/api", &secretReference{service: "secretsmanager", name: "prod/api"}},
+		{"secretsmanager:prod/api#key", &secretReference{service: "secretsmanager", name: "prod/api", jsonKey: "key"}},
+		{"ssm:", nil},
+		{"secretsmanager:", nil},
+		{"Hello ssm:/path", nil},
+		{"Hello!", nil},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.value, func(t *testing.T) {
+			reference := parseSecretReference(eachTest.value)
+			if !reflect.DeepEqual(reference, eachTest.expected) {
+				t.Errorf("Reference %+v, expected %+v", reference, eachTest.expected)
+			}
+		})
+	}
+}
+
+func TestEnvironmentVariables(t *testing.T) {
+	environment := &Environment{
+		Name: "production",
+		Variables: map[string]string{
+			"MESSAGE":     "Hello Production!",
+			"DB_PASSWORD": "ssm:/prod/db/password",
+			"API_KEY":     "secretsmanager:prod/api#key",
+		},
+	}
+	expected := map[string]string{
+		"MESSAGE":               "Hello Production!",
+		"DB_PASSWORD":           "ssm:/prod/db/password",
+		"API_KEY":               "secretsmanager:prod/api#key",
+		environmentNameVariable: "production",
+		secretKeysVariable:      "API_KEY,DB_PASSWORD",
+	}
+	variables := environmentVariables(environment)
+	if !reflect.DeepEqual(variables, expected) {
+		t.Errorf("Variables %+v, expected %+v", variables, expected)
+	}
+	if _, modified := environment.Variables[environmentNameVariable]; modified {
+		t.Errorf("Environment variables were modified: %+v", environment.Variables)
+	}
+}
+
+func TestRegisterEnvironmentReservedVariable(t *testing.T) {
+	for _, eachKey := range []string{environmentNameVariable, secretKeysVariable} {
+		registerErr := RegisterEnvironment(Environment{
+			Name:      "test",
+			Variables: map[string]string{eachKey: "test"},
+		})
+		if registerErr == nil {
+			t.Errorf("Registered an environment that defines %s", eachKey)
+		}
+	}
+}
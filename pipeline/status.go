@@ -0,0 +1,236 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codepipeline"
+	"github.com/aws/aws-sdk-go/service/codepipeline/codepipelineiface"
+	"github.com/mweagle/Sparta"
+	spartaAWS "github.com/mweagle/Sparta/aws"
+)
+
+// StatusOptions are the command line options necessary to report
+// the state of a provisioned pipeline
+type StatusOptions struct {
+	PipelineName string `validate:"required"`
+	Executions   int    `validate:"min=0"`
+	Output       string `validate:"eq=table|eq=json"`
+}
+
+// ActionStatus is the latest state of a single pipeline action
+type ActionStatus struct {
+	Name            string    `json:"name"`
+	Status          string    `json:"status,omitempty"`
+	Summary         string    `json:"summary,omitempty"`
+	RevisionID      string    `json:"revisionId,omitempty"`
+	RevisionSummary string    `json:"revisionSummary,omitempty"`
+	PendingApproval bool      `json:"pendingApproval"`
+	ApprovalToken   string    `json:"-"`
+	LastUpdated     time.Time `json:"lastUpdated,omitempty"`
+}
+
+// StageStatus is the latest state of a pipeline stage and its actions
+type StageStatus struct {
+	Name    string         `json:"name"`
+	Status  string         `json:"status,omitempty"`
+	Actions []ActionStatus `json:"actions"`
+}
+
+// ExecutionStatus summarizes a single pipeline execution
+type ExecutionStatus struct {
+	ExecutionID     string    `json:"executionId"`
+	Status          string    `json:"status"`
+	RevisionID      string    `json:"revisionId,omitempty"`
+	RevisionSummary string    `json:"revisionSummary,omitempty"`
+	StartTime       time.Time `json:"startTime"`
+	LastUpdateTime  time.Time `json:"lastUpdateTime"`
+}
+
+// PipelineStatus is the state of a provisioned pipeline
+type PipelineStatus struct {
+	PipelineName string            `json:"pipelineName"`
+	Stages       []StageStatus     `json:"stages"`
+	Executions   []ExecutionStatus `json:"executions"`
+}
+
+// PendingApprovals returns the actions that are waiting for an approval
+func (status *PipelineStatus) PendingApprovals() []ActionStatus {
+	pending := make([]ActionStatus, 0)
+	for _, eachStage := range status.Stages {
+		for _, eachAction := range eachStage.Actions {
+			if eachAction.PendingApproval {
+				pending = append(pending, eachAction)
+			}
+		}
+	}
+	return pending
+}
+
+// fetchPipelineStatus queries the current state and the most recent
+// executionCount executions of the named CodePipeline
+func fetchPipelineStatus(client codepipelineiface.CodePipelineAPI,
+	codePipelineName string,
+	executionCount int) (*PipelineStatus, error) {
+	stateOutput, stateErr := client.GetPipelineState(&codepipeline.GetPipelineStateInput{
+		Name: aws.String(codePipelineName),
+	})
+	if stateErr != nil {
+		return nil, stateErr
+	}
+	status := &PipelineStatus{
+		PipelineName: codePipelineName,
+		Stages:       make([]StageStatus, 0),
+		Executions:   make([]ExecutionStatus, 0),
+	}
+	for _, eachStage := range stateOutput.StageStates {
+		stageStatus := StageStatus{
+			Name:    aws.StringValue(eachStage.StageName),
+			Actions: make([]ActionStatus, 0),
+		}
+		if eachStage.LatestExecution != nil {
+			stageStatus.Status = aws.StringValue(eachStage.LatestExecution.Status)
+		}
+		for _, eachAction := range eachStage.ActionStates {
+			actionStatus := ActionStatus{
+				Name: aws.StringValue(eachAction.ActionName),
+			}
+			if eachAction.CurrentRevision != nil {
+				actionStatus.RevisionID = aws.StringValue(eachAction.CurrentRevision.RevisionId)
+			}
+			if eachAction.LatestExecution != nil {
+				latest := eachAction.LatestExecution
+				actionStatus.Status = aws.StringValue(latest.Status)
+				actionStatus.Summary = aws.StringValue(latest.Summary)
+				actionStatus.LastUpdated = aws.TimeValue(latest.LastStatusChange)
+				// Only approval actions carry a token while they're in progress
+				if latest.Token != nil &&
+					actionStatus.Status == codepipeline.ActionExecutionStatusInProgress {
+					actionStatus.PendingApproval = true
+					actionStatus.ApprovalToken = aws.StringValue(latest.Token)
+				}
+			}
+			stageStatus.Actions = append(stageStatus.Actions, actionStatus)
+		}
+		status.Stages = append(status.Stages, stageStatus)
+	}
+	if executionCount <= 0 {
+		return status, nil
+	}
+	executionsOutput, executionsErr := client.ListPipelineExecutions(&codepipeline.ListPipelineExecutionsInput{
+		PipelineName: aws.String(codePipelineName),
+		MaxResults:   aws.Int64(int64(executionCount)),
+	})
+	if executionsErr != nil {
+		return nil, executionsErr
+	}
+	for _, eachSummary := range executionsOutput.PipelineExecutionSummaries {
+		executionStatus := ExecutionStatus{
+			ExecutionID:    aws.StringValue(eachSummary.PipelineExecutionId),
+			Status:         aws.StringValue(eachSummary.Status),
+			StartTime:      aws.TimeValue(eachSummary.StartTime),
+			LastUpdateTime: aws.TimeValue(eachSummary.LastUpdateTime),
+		}
+		if len(eachSummary.SourceRevisions) != 0 {
+			executionStatus.RevisionID = aws.StringValue(eachSummary.SourceRevisions[0].RevisionId)
+			executionStatus.RevisionSummary = aws.StringValue(eachSummary.SourceRevisions[0].RevisionSummary)
+		}
+		status.Executions = append(status.Executions, executionStatus)
+	}
+	// Annotate the source actions with the commit message of the latest execution
+	if len(status.Executions) != 0 {
+		for stageIndex, eachStage := range status.Stages {
+			for actionIndex, eachAction := range eachStage.Actions {
+				if eachAction.RevisionID != "" &&
+					eachAction.RevisionID == status.Executions[0].RevisionID {
+					status.Stages[stageIndex].Actions[actionIndex].RevisionSummary = status.Executions[0].RevisionSummary
+				}
+			}
+		}
+	}
+	return status, nil
+}
+
+// writeStatusTable writes a human readable version of the status
+func writeStatusTable(status *PipelineStatus, writer io.Writer) error {
+	tabWriter := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tabWriter, "Pipeline: %s\n\n", status.PipelineName)
+	fmt.Fprintf(tabWriter, "STAGE\tACTION\tSTATUS\tREVISION\tUPDATED\n")
+	for _, eachStage := range status.Stages {
+		for _, eachAction := range eachStage.Actions {
+			actionStatus := eachAction.Status
+			if eachAction.PendingApproval {
+				actionStatus = "PendingApproval"
+			}
+			updated := ""
+			if !eachAction.LastUpdated.IsZero() {
+				updated = eachAction.LastUpdated.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tabWriter, "%s\t%s\t%s\t%s\t%s\n",
+				eachStage.Name,
+				eachAction.Name,
+				actionStatus,
+				shortRevision(eachAction.RevisionID),
+				updated)
+		}
+	}
+	if len(status.Executions) != 0 {
+		fmt.Fprintf(tabWriter, "\nEXECUTION\tSTATUS\tREVISION\tSTARTED\tMESSAGE\n")
+		for _, eachExecution := range status.Executions {
+			fmt.Fprintf(tabWriter, "%s\t%s\t%s\t%s\t%s\n",
+				eachExecution.ExecutionID,
+				eachExecution.Status,
+				shortRevision(eachExecution.RevisionID),
+				eachExecution.StartTime.Format(time.RFC3339),
+				firstLine(eachExecution.RevisionSummary))
+		}
+	}
+	return tabWriter.Flush()
+}
+
+// shortRevision abbreviates commit SHAs for display
+func shortRevision(revisionID string) string {
+	if len(revisionID) > 8 {
+		return revisionID[0:8]
+	}
+	return revisionID
+}
+
+func firstLine(message string) string {
+	return strings.SplitN(message, "\n", 2)[0]
+}
+
+// Status is responsible for reporting the state of the provisioned
+// CI/CD pipeline
+func Status(statusOptions *StatusOptions, writer io.Writer) error {
+	logger, loggerErr := sparta.NewLogger("info")
+	if loggerErr != nil {
+		return loggerErr
+	}
+	awsSession := spartaAWS.NewSession(logger)
+	codePipelineName, codePipelineNameErr := resolvePipelineName(statusOptions.PipelineName,
+		awsSession)
+	if codePipelineNameErr != nil {
+		return codePipelineNameErr
+	}
+	status, statusErr := fetchPipelineStatus(codepipeline.New(awsSession),
+		codePipelineName,
+		statusOptions.Executions)
+	if statusErr != nil {
+		return statusErr
+	}
+	if statusOptions.Output == "json" {
+		jsonBytes, jsonBytesErr := json.MarshalIndent(status, "", " ")
+		if jsonBytesErr != nil {
+			return jsonBytesErr
+		}
+		_, writeErr := fmt.Fprintln(writer, string(jsonBytes))
+		return writeErr
+	}
+	return writeStatusTable(status, writer)
+}
@@ -0,0 +1,326 @@
+package pipeline
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codepipeline"
+	"github.com/aws/aws-sdk-go/service/codepipeline/codepipelineiface"
+)
+
+// mockCodePipeline returns canned pipeline state and executions
+type mockCodePipeline struct {
+	codepipelineiface.CodePipelineAPI
+	state           *codepipeline.GetPipelineStateOutput
+	executions      *codepipeline.ListPipelineExecutionsOutput
+	executionsInput *codepipeline.ListPipelineExecutionsInput
+}
+
+func (mock *mockCodePipeline) GetPipelineState(input *codepipeline.GetPipelineStateInput) (*codepipeline.GetPipelineStateOutput, error) {
+	return mock.state, nil
+}
+
+func (mock *mockCodePipeline) ListPipelineExecutions(input *codepipeline.ListPipelineExecutionsInput) (*codepipeline.ListPipelineExecutionsOutput, error) {
+	mock.executionsInput = input
+	return mock.executions, nil
+}
+
+var statusTestTime = time.Date(2018, 2, 1, 12, 0, 0, 0, time.UTC)
+
+func testStageState(stageName string,
+	stageStatus string,
+	actions ...*codepipeline.ActionState) *codepipeline.StageState {
+	stage := &codepipeline.StageState{
+		StageName:    aws.String(stageName),
+		ActionStates: actions,
+	}
+	if stageStatus != "" {
+		stage.LatestExecution = &codepipeline.StageExecution{
+			Status: aws.String(stageStatus),
+		}
+	}
+	return stage
+}
+
+func testActionState(actionName string,
+	actionStatus string,
+	token string,
+	revisionID string) *codepipeline.ActionState {
+	action := &codepipeline.ActionState{
+		ActionName: aws.String(actionName),
+		LatestExecution: &codepipeline.ActionExecution{
+			Status:           aws.String(actionStatus),
+			Summary:          aws.String(actionName + " summary"),
+			LastStatusChange: aws.Time(statusTestTime),
+		},
+	}
+	if token != "" {
+		action.LatestExecution.Token = aws.String(token)
+	}
+	if revisionID != "" {
+		action.CurrentRevision = &codepipeline.ActionRevision{
+			RevisionId: aws.String(revisionID),
+		}
+	}
+	return action
+}
+
+func TestFetchPipelineStatusStages(t *testing.T) {
+	tests := []struct {
+		name        string
+		stage       *codepipeline.StageState
+		stageStatus string
+		action      ActionStatus
+	}{
+		{
+			name: "succeeded source",
+			stage: testStageState("Source", "Succeeded",
+				testActionState("GitHub", "Succeeded", "", "0123456789abcdef")),
+			stageStatus: "Succeeded",
+			action: ActionStatus{
+				Name:       "GitHub",
+				Status:     "Succeeded",
+				Summary:    "GitHub summary",
+				RevisionID: "0123456789abcdef",
+			},
+		},
+		{
+			name: "pending approval",
+			stage: testStageState("ProdStage", "InProgress",
+				testActionState("Approve", codepipeline.ActionExecutionStatusInProgress, "approval-token", "")),
+			stageStatus: "InProgress",
+			action: ActionStatus{
+				Name:            "Approve",
+				Status:          codepipeline.ActionExecutionStatusInProgress,
+				Summary:         "Approve summary",
+				PendingApproval: true,
+				ApprovalToken:   "approval-token",
+			},
+		},
+		{
+			name: "completed approval",
+			stage: testStageState("ProdStage", "Succeeded",
+				testActionState("Approve", codepipeline.ActionExecutionStatusSucceeded, "approval-token", "")),
+			stageStatus: "Succeeded",
+			action: ActionStatus{
+				Name:    "Approve",
+				Status:  codepipeline.ActionExecutionStatusSucceeded,
+				Summary: "Approve summary",
+			},
+		},
+		{
+			name: "stage never executed",
+			stage: testStageState("TestStage", "",
+				testActionState("CreateStack", "Failed", "", "")),
+			stageStatus: "",
+			action: ActionStatus{
+				Name:    "CreateStack",
+				Status:  "Failed",
+				Summary: "CreateStack summary",
+			},
+		},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.name, func(t *testing.T) {
+			client := &mockCodePipeline{
+				state: &codepipeline.GetPipelineStateOutput{
+					StageStates: []*codepipeline.StageState{eachTest.stage},
+				},
+			}
+			status, statusErr := fetchPipelineStatus(client, "pipeline", 0)
+			if statusErr != nil {
+				t.Fatal(statusErr)
+			}
+			if client.executionsInput != nil {
+				t.Errorf("Executions were listed for an execution count of 0")
+			}
+			if len(status.Stages) != 1 || len(status.Stages[0].Actions) != 1 {
+				t.Fatalf("Unexpected stages: %+v", status.Stages)
+			}
+			if status.Stages[0].Status != eachTest.stageStatus {
+				t.Errorf("Stage status %q, expected %q", status.Stages[0].Status, eachTest.stageStatus)
+			}
+			expectedAction := eachTest.action
+			expectedAction.LastUpdated = statusTestTime
+			if status.Stages[0].Actions[0] != expectedAction {
+				t.Errorf("Action %+v, expected %+v", status.Stages[0].Actions[0], expectedAction)
+			}
+			pending := status.PendingApprovals()
+			if (len(pending) == 1) != expectedAction.PendingApproval {
+				t.Errorf("Pending approvals %+v, expected pending: %t", pending, expectedAction.PendingApproval)
+			}
+		})
+	}
+}
+
+func TestFetchPipelineStatusExecutions(t *testing.T) {
+	tests := []struct {
+		name            string
+		summaries       []*codepipeline.PipelineExecutionSummary
+		expected        []ExecutionStatus
+		revisionSummary string
+	}{
+		{
+			name:     "no executions",
+			expected: []ExecutionStatus{},
+		},
+		{
+			name: "latest execution annotates the source action",
+			summaries: []*codepipeline.PipelineExecutionSummary{
+				{
+					PipelineExecutionId: aws.String("execution-2"),
+					Status:              aws.String("InProgress"),
+					StartTime:           aws.Time(statusTestTime),
+					LastUpdateTime:      aws.Time(statusTestTime),
+					SourceRevisions: []*codepipeline.SourceRevision{
+						{
+							RevisionId:      aws.String("0123456789abcdef"),
+							RevisionSummary: aws.String("Fix the build\n\nDetails"),
+						},
+					},
+				},
+				{
+					PipelineExecutionId: aws.String("execution-1"),
+					Status:              aws.String("Failed"),
+					StartTime:           aws.Time(statusTestTime),
+					LastUpdateTime:      aws.Time(statusTestTime),
+				},
+			},
+			expected: []ExecutionStatus{
+				{
+					ExecutionID:     "execution-2",
+					Status:          "InProgress",
+					RevisionID:      "0123456789abcdef",
+					RevisionSummary: "Fix the build\n\nDetails",
+					StartTime:       statusTestTime,
+					LastUpdateTime:  statusTestTime,
+				},
+				{
+					ExecutionID:    "execution-1",
+					Status:         "Failed",
+					StartTime:      statusTestTime,
+					LastUpdateTime: statusTestTime,
+				},
+			},
+			revisionSummary: "Fix the build\n\nDetails",
+		},
+		{
+			name: "older revision doesn't annotate the source action",
+			summaries: []*codepipeline.PipelineExecutionSummary{
+				{
+					PipelineExecutionId: aws.String("execution-3"),
+					Status:              aws.String("Succeeded"),
+					StartTime:           aws.Time(statusTestTime),
+					LastUpdateTime:      aws.Time(statusTestTime),
+					SourceRevisions: []*codepipeline.SourceRevision{
+						{
+							RevisionId:      aws.String("fedcba9876543210"),
+							RevisionSummary: aws.String("Older commit"),
+						},
+					},
+				},
+			},
+			expected: []ExecutionStatus{
+				{
+					ExecutionID:     "execution-3",
+					Status:          "Succeeded",
+					RevisionID:      "fedcba9876543210",
+					RevisionSummary: "Older commit",
+					StartTime:       statusTestTime,
+					LastUpdateTime:  statusTestTime,
+				},
+			},
+		},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.name, func(t *testing.T) {
+			client := &mockCodePipeline{
+				state: &codepipeline.GetPipelineStateOutput{
+					StageStates: []*codepipeline.StageState{
+						testStageState("Source", "Succeeded",
+							testActionState("GitHub", "Succeeded", "", "0123456789abcdef")),
+					},
+				},
+				executions: &codepipeline.ListPipelineExecutionsOutput{
+					PipelineExecutionSummaries: eachTest.summaries,
+				},
+			}
+			status, statusErr := fetchPipelineStatus(client, "pipeline", 5)
+			if statusErr != nil {
+				t.Fatal(statusErr)
+			}
+			if aws.Int64Value(client.executionsInput.MaxResults) != 5 {
+				t.Errorf("Listed %d executions, expected 5", aws.Int64Value(client.executionsInput.MaxResults))
+			}
+			if len(status.Executions) != len(eachTest.expected) {
+				t.Fatalf("Executions %+v, expected %+v", status.Executions, eachTest.expected)
+			}
+			for eachIndex, eachExecution := range status.Executions {
+				if eachExecution != eachTest.expected[eachIndex] {
+					t.Errorf("Execution %+v, expected %+v", eachExecution, eachTest.expected[eachIndex])
+				}
+			}
+			sourceAction := status.Stages[0].Actions[0]
+			if sourceAction.RevisionSummary != eachTest.revisionSummary {
+				t.Errorf("Source action revision summary %q, expected %q",
+					sourceAction.RevisionSummary,
+					eachTest.revisionSummary)
+			}
+		})
+	}
+}
+
+func TestWriteStatusTable(t *testing.T) {
+	status := &PipelineStatus{
+		PipelineName: "pipeline",
+		Stages: []StageStatus{
+			{
+				Name: "ProdStage",
+				Actions: []ActionStatus{
+					{
+						Name:            "Approve",
+						Status:          codepipeline.ActionExecutionStatusInProgress,
+						PendingApproval: true,
+					},
+					{
+						Name:        "CreateStack",
+						Status:      "Succeeded",
+						RevisionID:  "0123456789abcdef",
+						LastUpdated: statusTestTime,
+					},
+				},
+			},
+		},
+		Executions: []ExecutionStatus{
+			{
+				ExecutionID:     "execution-1",
+				Status:          "Succeeded",
+				RevisionID:      "0123456789abcdef",
+				RevisionSummary: "Fix the build\n\nDetails",
+				StartTime:       statusTestTime,
+			},
+		},
+	}
+	output := &bytes.Buffer{}
+	writeErr := writeStatusTable(status, output)
+	if writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	for _, eachExpected := range []string{
+		"Pipeline: pipeline",
+		"PendingApproval",
+		"01234567 ",
+		"2018-02-01T12:00:00Z",
+		"Fix the build",
+	} {
+		if !strings.Contains(output.String(), eachExpected) {
+			t.Errorf("Status table doesn't include %q:\n%s", eachExpected, output.String())
+		}
+	}
+	if strings.Contains(output.String(), "Details") {
+		t.Errorf("Status table includes more than the first line of the commit message:\n%s", output.String())
+	}
+}
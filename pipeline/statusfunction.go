@@ -0,0 +1,129 @@
+package pipeline
+
+import (
+	"strings"
+
+	"github.com/mweagle/Sparta"
+	spartaIAM "github.com/mweagle/Sparta/aws/iam"
+	gocf "github.com/mweagle/go-cloudformation"
+)
+
+// Logical resource names of the optional commit status function
+var (
+	commitStatusRoleResource = sparta.CloudFormationResourceName("CommitStatusRole",
+		"CommitStatusRole")
+	commitStatusFunctionResource = sparta.CloudFormationResourceName("CommitStatusFunction",
+		"CommitStatusFunction")
+	commitStatusRuleResource = sparta.CloudFormationResourceName("CommitStatusRule",
+		"CommitStatusRule")
+	commitStatusPermissionResource = sparta.CloudFormationResourceName("CommitStatusPermission",
+		"CommitStatusPermission")
+)
+
+// commitStatusFunction is the Lambda function that posts GitHub
+// commit statuses
+var commitStatusFunction = &pipelineFunction{
+	handlerName:  "commitstatus",
+	importPath:   "github.com/mweagle/SpartaCodePipeline/cmd/commitstatus",
+	codeKeyParam: "CommitStatusCodeKey",
+}
+
+// commitStatusAPIURL returns the GitHub REST API endpoint of the commit
+// status function
+func commitStatusAPIURL(provisionOptions *ProvisionOptions) string {
+	if provisionOptions.GitHubAPIURL == "" {
+		return githubAPIURL
+	}
+	return strings.TrimSuffix(provisionOptions.GitHubAPIURL, "/")
+}
+
+// addCommitStatusFunction adds the Lambda function that posts a GitHub
+// commit status for every stage state change of the pipeline
+func addCommitStatusFunction(cfTemplate *gocf.Template,
+	provisionOptions *ProvisionOptions,
+	source *gitHubRepo) {
+	commitStatusFunction.addCodeKeyParameter(cfTemplate, provisionOptions.PipelineName)
+
+	commitStatusRole := &gocf.IAMRole{
+		Path:                     gocf.String("/"),
+		AssumeRolePolicyDocument: AssumePolicyLambdaRoleDocument,
+		Policies: &gocf.IAMRolePolicyList{
+			gocf.IAMRolePolicy{
+				PolicyName: gocf.String("CommitStatusRole"),
+				PolicyDocument: sparta.ArbitraryJSONObject{
+					"Version": "2012-10-17",
+					"Statement": []spartaIAM.PolicyStatement{
+						spartaIAM.PolicyStatement{
+							Action: []string{"logs:CreateLogGroup",
+								"logs:CreateLogStream",
+								"logs:PutLogEvents"},
+							Effect:   "Allow",
+							Resource: gocf.String("*"),
+						},
+						spartaIAM.PolicyStatement{
+							Action:   []string{"codepipeline:GetPipelineExecution"},
+							Effect:   "Allow",
+							Resource: gocf.String("*"),
+						},
+						spartaIAM.PolicyStatement{
+							Action:   []string{"secretsmanager:GetSecretValue"},
+							Effect:   "Allow",
+							Resource: secretsManagerSecretArn(provisionOptions.GitHubTokenSecret),
+						},
+					},
+				},
+			},
+		},
+	}
+	cfTemplate.AddResource(commitStatusRoleResource, commitStatusRole)
+
+	statusFunction := &gocf.LambdaFunction{
+		Description: gocf.String("Posts GitHub commit statuses for the pipeline stages"),
+		Code:        commitStatusFunction.code(provisionOptions.S3Bucket),
+		Handler:     gocf.String(lambdaBootstrap),
+		Runtime:     gocf.String(lambdaRuntime),
+		Role:        gocf.GetAtt(commitStatusRoleResource, "Arn"),
+		Timeout:     gocf.Integer(30),
+		Environment: &gocf.LambdaFunctionEnvironment{
+			Variables: map[string]interface{}{
+				envGitHubAPIURL:      commitStatusAPIURL(provisionOptions),
+				envGitHubOwner:       source.Owner,
+				envGitHubRepo:        source.Repo,
+				envGitHubTokenSecret: provisionOptions.GitHubTokenSecret,
+			},
+		},
+	}
+	cfTemplate.AddResource(commitStatusFunctionResource, statusFunction)
+
+	commitStatusRule := &gocf.EventsRule{
+		Description: gocf.String("Pipeline stage state changes"),
+		EventPattern: sparta.ArbitraryJSONObject{
+			"source":      []string{"aws.codepipeline"},
+			"detail-type": []string{"CodePipeline Stage Execution State Change"},
+			"detail": sparta.ArbitraryJSONObject{
+				"pipeline": []interface{}{gocf.Ref(codePipelineResource)},
+			},
+		},
+		State: gocf.String("ENABLED"),
+		Targets: &gocf.EventsRuleTargetList{
+			gocf.EventsRuleTarget{
+				Arn: gocf.GetAtt(commitStatusFunctionResource, "Arn"),
+				ID:  gocf.String("CommitStatus"),
+			},
+		},
+	}
+	cfTemplate.AddResource(commitStatusRuleResource, commitStatusRule)
+
+	commitStatusPermission := &gocf.LambdaPermission{
+		Action:       gocf.String("lambda:InvokeFunction"),
+		FunctionName: gocf.GetAtt(commitStatusFunctionResource, "Arn"),
+		Principal:    gocf.String("events.amazonaws.com"),
+		SourceArn:    gocf.GetAtt(commitStatusRuleResource, "Arn"),
+	}
+	cfTemplate.AddResource(commitStatusPermissionResource, commitStatusPermission)
+
+	cfTemplate.Outputs["CommitStatusFunctionArn"] = &gocf.Output{
+		Description: "Commit status Lambda function ARN",
+		Value:       gocf.GetAtt(commitStatusFunctionResource, "Arn"),
+	}
+}
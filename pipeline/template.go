@@ -0,0 +1,102 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ghodss/yaml"
+	gocf "github.com/mweagle/go-cloudformation"
+)
+
+// stdoutPath is the --out value that writes the template to stdout
+const stdoutPath = "-"
+
+// templateOutputPath returns the path the template is written to, defaulting
+// to the .sparta scratch directory
+func templateOutputPath(provisionOptions *ProvisionOptions) string {
+	if provisionOptions.OutputPath != "" {
+		return provisionOptions.OutputPath
+	}
+	return filepath.Join("./.sparta", fmt.Sprintf("pipeline.%s", templateFormat(provisionOptions)))
+}
+
+// templateFormat returns the template serialization format
+func templateFormat(provisionOptions *ProvisionOptions) string {
+	if provisionOptions.Format == "" {
+		return "json"
+	}
+	return provisionOptions.Format
+}
+
+// marshalTemplate serializes the template in the given format
+func marshalTemplate(cfTemplate *gocf.Template, format string) ([]byte, error) {
+	jsonBytes, jsonBytesErr := json.MarshalIndent(cfTemplate, "", " ")
+	if jsonBytesErr != nil {
+		return nil, jsonBytesErr
+	}
+	switch format {
+	case "json":
+		return jsonBytes, nil
+	case "yaml":
+		return yaml.JSONToYAML(jsonBytes)
+	default:
+		return nil, fmt.Errorf("Unsupported template format: %s", format)
+	}
+}
+
+// parseTemplateBody parses a deployed template body. Provision uploads the
+// template in the --format format, so the body is either JSON or YAML.
+func parseTemplateBody(templateBody string) (map[string]interface{}, error) {
+	jsonBytes, jsonBytesErr := yaml.YAMLToJSON([]byte(templateBody))
+	if jsonBytesErr != nil {
+		return nil, jsonBytesErr
+	}
+	parsed := make(map[string]interface{})
+	unmarshalErr := json.Unmarshal(jsonBytes, &parsed)
+	if unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+	return parsed, nil
+}
+
+// writeTemplate writes the template to the configured output, creating
+// parent directories as needed. It returns the path of a file containing
+// the template that can be uploaded. When the output is stdout, that file
+// is a temporary file.
+func writeTemplate(cfTemplate *gocf.Template,
+	provisionOptions *ProvisionOptions) (string, error) {
+	format := templateFormat(provisionOptions)
+	templateBytes, templateBytesErr := marshalTemplate(cfTemplate, format)
+	if templateBytesErr != nil {
+		return "", templateBytesErr
+	}
+	outputPath := templateOutputPath(provisionOptions)
+	if outputPath == stdoutPath {
+		_, stdoutErr := os.Stdout.Write(templateBytes)
+		if stdoutErr != nil {
+			return "", stdoutErr
+		}
+		tempFile, tempFileErr := ioutil.TempFile("", fmt.Sprintf("pipeline-*.%s", format))
+		if tempFileErr != nil {
+			return "", tempFileErr
+		}
+		defer tempFile.Close()
+		_, writeErr := tempFile.Write(templateBytes)
+		if writeErr != nil {
+			return "", writeErr
+		}
+		return tempFile.Name(), nil
+	}
+	mkdirErr := os.MkdirAll(filepath.Dir(outputPath), os.ModePerm)
+	if mkdirErr != nil {
+		return "", mkdirErr
+	}
+	writeErr := ioutil.WriteFile(outputPath, templateBytes, 0644)
+	if writeErr != nil {
+		return "", writeErr
+	}
+	return outputPath, nil
+}
@@ -0,0 +1,44 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ghodss/yaml"
+)
+
+func TestParseTemplateBody(t *testing.T) {
+	jsonBody := `{
+ "Parameters": {
+  "PipelineName": {"Type": "String", "Default": "true"},
+  "Timeout": {"Type": "Number", "Default": 10}
+ },
+ "Resources": {
+  "Topic": {
+   "Type": "AWS::SNS::Topic",
+   "Properties": {"TopicName": {"Fn::Join": ["-", [{"Ref": "PipelineName"}, "topic"]]}}
+  }
+ }
+}`
+	yamlBody, yamlBodyErr := yaml.JSONToYAML([]byte(jsonBody))
+	if yamlBodyErr != nil {
+		t.Fatal(yamlBodyErr)
+	}
+	expected, expectedErr := parseTemplateBody(jsonBody)
+	if expectedErr != nil {
+		t.Fatal(expectedErr)
+	}
+	if expected["Resources"] == nil {
+		t.Fatalf("Parsed JSON template has no resources: %+v", expected)
+	}
+	parsed, parsedErr := parseTemplateBody(string(yamlBody))
+	if parsedErr != nil {
+		t.Fatal(parsedErr)
+	}
+	if !reflect.DeepEqual(parsed, expected) {
+		t.Errorf("YAML template %+v, expected %+v", parsed, expected)
+	}
+	if _, invalidErr := parseTemplateBody("{"); invalidErr == nil {
+		t.Error("Expected an error for an invalid template body")
+	}
+}
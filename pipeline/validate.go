@@ -0,0 +1,361 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/sirupsen/logrus"
+)
+
+// knownActionProviders are the CodePipeline action providers, keyed by
+// owner and category
+var knownActionProviders = map[string]map[string][]string{
+	"AWS": {
+		"Source":   {"S3", "CodeCommit", "ECR", "CodeStarSourceConnection"},
+		"Build":    {"CodeBuild", "ECRBuildAndPublish"},
+		"Test":     {"CodeBuild", "DeviceFarm"},
+		"Deploy":   {"CloudFormation", "CloudFormationStackSet", "CodeDeploy", "ECS", "CodeDeployToECS", "ElasticBeanstalk", "S3", "ServiceCatalog"},
+		"Approval": {"Manual"},
+		"Invoke":   {"Lambda", "StepFunctions"},
+	},
+	"ThirdParty": {
+		"Source": {"GitHub"},
+	},
+	"Custom": {},
+}
+
+// Physical name limits enforced by the offline validator
+const (
+	maxLogicalIDLength      = 255
+	maxStackNameLength      = 128
+	maxChangeSetNameLength  = 128
+	maxCodeBuildNameLength  = 255
+	maxPipelineActionLength = 100
+)
+
+var logicalIDRE = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+var codeBuildNameRE = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9\-_]{1,254}$`)
+var stackNameRE = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9\-]*$`)
+
+// validationIssue is a single problem found in the generated template
+type validationIssue struct {
+	Path    string
+	Message string
+	IsError bool
+}
+
+// templateValidator accumulates the issues found in a template
+type templateValidator struct {
+	template map[string]interface{}
+	issues   []validationIssue
+}
+
+func (validator *templateValidator) errorf(path string, format string, args ...interface{}) {
+	validator.issues = append(validator.issues, validationIssue{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+		IsError: true,
+	})
+}
+
+func (validator *templateValidator) warnf(path string, format string, args ...interface{}) {
+	validator.issues = append(validator.issues, validationIssue{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (validator *templateValidator) section(name string) map[string]interface{} {
+	section, _ := validator.template[name].(map[string]interface{})
+	if section == nil {
+		section = map[string]interface{}{}
+	}
+	return section
+}
+
+// validateReferences ensures every Ref and Fn::GetAtt target is defined
+func (validator *templateValidator) validateReferences(path string, value interface{}) {
+	parameters := validator.section("Parameters")
+	resources := validator.section("Resources")
+
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		for eachKey, eachValue := range typedValue {
+			childPath := fmt.Sprintf("%s.%s", path, eachKey)
+			switch eachKey {
+			case "Ref":
+				target, _ := eachValue.(string)
+				_, isParam := parameters[target]
+				_, isResource := resources[target]
+				if !isParam && !isResource && !strings.HasPrefix(target, "AWS::") {
+					validator.errorf(childPath, "Unresolved Ref target: %s", target)
+				}
+			case "Fn::GetAtt":
+				attParts, _ := eachValue.([]interface{})
+				if len(attParts) != 2 {
+					validator.errorf(childPath, "Fn::GetAtt requires [LogicalID, Attribute]")
+					continue
+				}
+				target, _ := attParts[0].(string)
+				if _, isResource := resources[target]; !isResource {
+					validator.errorf(childPath, "Unresolved Fn::GetAtt target: %s", target)
+				}
+			default:
+				validator.validateReferences(childPath, eachValue)
+			}
+		}
+	case []interface{}:
+		for eachIndex, eachValue := range typedValue {
+			validator.validateReferences(fmt.Sprintf("%s[%d]", path, eachIndex), eachValue)
+		}
+	}
+}
+
+// validateLiteralName checks the length and characters of a physical name,
+// skipping values that are computed by CloudFormation
+func (validator *templateValidator) validateLiteralName(path string,
+	value interface{},
+	maxLength int,
+	nameRE *regexp.Regexp) {
+	literal, isLiteral := value.(string)
+	if !isLiteral {
+		return
+	}
+	if len(literal) > maxLength {
+		validator.errorf(path, "%s exceeds the %d character limit (%d)", literal, maxLength, len(literal))
+	}
+	if nameRE != nil && !nameRE.MatchString(literal) {
+		validator.errorf(path, "%s contains invalid characters", literal)
+	}
+}
+
+// validateNames enforces resource name length and character limits
+func (validator *templateValidator) validateNames() {
+	for eachName, eachResource := range validator.section("Resources") {
+		resourcePath := fmt.Sprintf("Resources.%s", eachName)
+		if len(eachName) > maxLogicalIDLength || !logicalIDRE.MatchString(eachName) {
+			validator.errorf(resourcePath, "Invalid logical resource ID: %s", eachName)
+		}
+		resourceMap, _ := eachResource.(map[string]interface{})
+		properties, _ := resourceMap["Properties"].(map[string]interface{})
+		if resourceMap["Type"] == "AWS::CodeBuild::Project" {
+			validator.validateLiteralName(resourcePath+".Properties.Name",
+				properties["Name"],
+				maxCodeBuildNameLength,
+				codeBuildNameRE)
+		}
+	}
+	for eachName, eachParam := range validator.section("Parameters") {
+		if !strings.HasSuffix(eachName, "StackName") {
+			continue
+		}
+		paramMap, _ := eachParam.(map[string]interface{})
+		validator.validateLiteralName(fmt.Sprintf("Parameters.%s.Default", eachName),
+			paramMap["Default"],
+			maxStackNameLength,
+			stackNameRE)
+	}
+}
+
+// validatePipeline checks the stages and actions of a CodePipeline resource
+func (validator *templateValidator) validatePipeline(resourceName string,
+	properties map[string]interface{}) {
+	stages, _ := properties["Stages"].([]interface{})
+	stageNames := make(map[string]bool)
+	availableArtifacts := make(map[string]bool)
+
+	for stageIndex, eachStage := range stages {
+		stageMap, _ := eachStage.(map[string]interface{})
+		stageName, _ := stageMap["Name"].(string)
+		stagePath := fmt.Sprintf("Resources.%s.Properties.Stages[%d]", resourceName, stageIndex)
+		if stageNames[stageName] {
+			validator.errorf(stagePath, "Duplicate stage name: %s", stageName)
+		}
+		stageNames[stageName] = true
+
+		actions, _ := stageMap["Actions"].([]interface{})
+		if len(actions) == 0 {
+			validator.errorf(stagePath, "Stage %s has no actions", stageName)
+			continue
+		}
+		actionNames := make(map[string]bool)
+		// Artifacts produced in this stage, by RunOrder
+		stageOutputs := make(map[int][]string)
+		runOrders := make(map[int]bool)
+		type actionInputs struct {
+			path     string
+			runOrder int
+			inputs   []string
+		}
+		pendingInputs := make([]actionInputs, 0)
+
+		for actionIndex, eachAction := range actions {
+			actionMap, _ := eachAction.(map[string]interface{})
+			actionName, _ := actionMap["Name"].(string)
+			actionPath := fmt.Sprintf("%s.Actions[%d]", stagePath, actionIndex)
+			if actionNames[actionName] {
+				validator.errorf(actionPath, "Duplicate action name in stage %s: %s", stageName, actionName)
+			}
+			actionNames[actionName] = true
+			if len(actionName) > maxPipelineActionLength {
+				validator.errorf(actionPath, "Action name %s exceeds the %d character limit",
+					actionName,
+					maxPipelineActionLength)
+			}
+
+			// Provider/category
+			typeID, _ := actionMap["ActionTypeId"].(map[string]interface{})
+			owner, _ := typeID["Owner"].(string)
+			category, _ := typeID["Category"].(string)
+			provider, _ := typeID["Provider"].(string)
+			categories, knownOwner := knownActionProviders[owner]
+			if !knownOwner {
+				validator.errorf(actionPath, "Unknown action owner: %s", owner)
+			} else if owner != "Custom" {
+				providers, knownCategory := categories[category]
+				if !knownCategory {
+					validator.errorf(actionPath, "Unknown action category for %s owner: %s", owner, category)
+				} else if !containsString(providers, provider) {
+					validator.errorf(actionPath, "Unknown %s action provider: %s", category, provider)
+				}
+			}
+
+			configuration, _ := actionMap["Configuration"].(map[string]interface{})
+			validator.validateLiteralName(actionPath+".Configuration.ChangeSetName",
+				configuration["ChangeSetName"],
+				maxChangeSetNameLength,
+				stackNameRE)
+
+			runOrder := 1
+			if runOrderValue, hasRunOrder := actionMap["RunOrder"].(float64); hasRunOrder {
+				runOrder = int(runOrderValue)
+			}
+			runOrders[runOrder] = true
+
+			inputs := make([]string, 0)
+			inputArtifacts, _ := actionMap["InputArtifacts"].([]interface{})
+			for _, eachInput := range inputArtifacts {
+				inputMap, _ := eachInput.(map[string]interface{})
+				inputName, _ := inputMap["Name"].(string)
+				inputs = append(inputs, inputName)
+			}
+			pendingInputs = append(pendingInputs, actionInputs{actionPath, runOrder, inputs})
+
+			outputArtifacts, _ := actionMap["OutputArtifacts"].([]interface{})
+			for _, eachOutput := range outputArtifacts {
+				outputMap, _ := eachOutput.(map[string]interface{})
+				outputName, _ := outputMap["Name"].(string)
+				producedInStage := false
+				for _, eachOutputs := range stageOutputs {
+					producedInStage = producedInStage || containsString(eachOutputs, outputName)
+				}
+				if availableArtifacts[outputName] || producedInStage {
+					validator.errorf(actionPath, "Output artifact %s is already produced by an earlier action", outputName)
+				}
+				stageOutputs[runOrder] = append(stageOutputs[runOrder], outputName)
+			}
+		}
+		// Inputs must be produced by an earlier stage or by an action in
+		// this stage with a lower RunOrder
+		for _, eachPending := range pendingInputs {
+			for _, eachInput := range eachPending.inputs {
+				produced := availableArtifacts[eachInput]
+				for eachRunOrder, eachOutputs := range stageOutputs {
+					if eachRunOrder < eachPending.runOrder && containsString(eachOutputs, eachInput) {
+						produced = true
+					}
+				}
+				if !produced {
+					validator.errorf(eachPending.path, "Input artifact %s is not produced by an earlier action", eachInput)
+				}
+			}
+		}
+		for _, eachOutputs := range stageOutputs {
+			for _, eachOutput := range eachOutputs {
+				availableArtifacts[eachOutput] = true
+			}
+		}
+		// RunOrders should be contiguous, starting at 1
+		for eachRunOrder := 1; eachRunOrder <= len(runOrders); eachRunOrder++ {
+			if !runOrders[eachRunOrder] {
+				validator.warnf(stagePath, "Stage %s RunOrder values have a gap at %d", stageName, eachRunOrder)
+				break
+			}
+		}
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, eachValue := range values {
+		if eachValue == value {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTemplate runs the offline checks against the generated template
+// and returns the issues sorted by path
+func validateTemplate(cfTemplate *gocf.Template) ([]validationIssue, error) {
+	jsonBytes, jsonBytesErr := json.Marshal(cfTemplate)
+	if jsonBytesErr != nil {
+		return nil, jsonBytesErr
+	}
+	return validateTemplateJSON(jsonBytes)
+}
+
+// validateTemplateJSON runs the offline checks against the JSON template
+// and returns the issues sorted by path
+func validateTemplateJSON(jsonBytes []byte) ([]validationIssue, error) {
+	validator := &templateValidator{
+		template: make(map[string]interface{}),
+		issues:   make([]validationIssue, 0),
+	}
+	unmarshalErr := json.Unmarshal(jsonBytes, &validator.template)
+	if unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+	for _, eachSection := range []string{"Resources", "Outputs"} {
+		validator.validateReferences(eachSection, validator.section(eachSection))
+	}
+	validator.validateNames()
+	for eachName, eachResource := range validator.section("Resources") {
+		resourceMap, _ := eachResource.(map[string]interface{})
+		if resourceMap["Type"] == "AWS::CodePipeline::Pipeline" {
+			properties, _ := resourceMap["Properties"].(map[string]interface{})
+			validator.validatePipeline(eachName, properties)
+		}
+	}
+	sort.SliceStable(validator.issues, func(i, j int) bool {
+		return validator.issues[i].Path < validator.issues[j].Path
+	})
+	return validator.issues, nil
+}
+
+// logValidationIssues logs the issues and returns an error if any of
+// them are errors
+func logValidationIssues(issues []validationIssue, logger *logrus.Logger) error {
+	errorCount := 0
+	for _, eachIssue := range issues {
+		entry := logger.WithFields(logrus.Fields{
+			"Path": eachIssue.Path,
+		})
+		if eachIssue.IsError {
+			errorCount++
+			entry.Error(eachIssue.Message)
+		} else {
+			entry.Warn(eachIssue.Message)
+		}
+	}
+	if errorCount != 0 {
+		return fmt.Errorf("Pipeline template failed validation with %d error(s)", errorCount)
+	}
+	logger.WithFields(logrus.Fields{
+		"Warnings": len(issues),
+	}).Info("Pipeline template passed offline validation")
+	return nil
+}
@@ -0,0 +1,318 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testActionTypes are the action type IDs of the providers used in the
+// validation tests
+var testActionTypes = map[string]map[string]interface{}{
+	"GitHub":         {"Owner": "ThirdParty", "Category": "Source", "Provider": "GitHub"},
+	"CodeBuild":      {"Owner": "AWS", "Category": "Build", "Provider": "CodeBuild"},
+	"CloudFormation": {"Owner": "AWS", "Category": "Deploy", "Provider": "CloudFormation"},
+	"Manual":         {"Owner": "AWS", "Category": "Approval", "Provider": "Manual"},
+}
+
+func testArtifacts(names []string) []interface{} {
+	artifacts := make([]interface{}, 0, len(names))
+	for _, eachName := range names {
+		artifacts = append(artifacts, map[string]interface{}{"Name": eachName})
+	}
+	return artifacts
+}
+
+func testAction(name string,
+	provider string,
+	runOrder int,
+	inputs []string,
+	outputs []string) map[string]interface{} {
+	return map[string]interface{}{
+		"Name":            name,
+		"ActionTypeId":    testActionTypes[provider],
+		"RunOrder":        runOrder,
+		"InputArtifacts":  testArtifacts(inputs),
+		"OutputArtifacts": testArtifacts(outputs),
+		"Configuration":   map[string]interface{}{},
+	}
+}
+
+func testStage(name string, actions ...map[string]interface{}) map[string]interface{} {
+	stageActions := make([]interface{}, 0, len(actions))
+	for _, eachAction := range actions {
+		stageActions = append(stageActions, eachAction)
+	}
+	return map[string]interface{}{
+		"Name":    name,
+		"Actions": stageActions,
+	}
+}
+
+// testSourceStage and testBuildStage produce the Source and Template
+// artifacts
+func testSourceStage() map[string]interface{} {
+	return testStage("Source", testAction("GitHub", "GitHub", 1, nil, []string{"Source"}))
+}
+
+func testBuildStage() map[string]interface{} {
+	return testStage("Build", testAction("Build", "CodeBuild", 1, []string{"Source"}, []string{"Template"}))
+}
+
+// testTemplate returns a template with a CodeBuild project, an environment
+// stack name parameter and a pipeline with the stages
+func testTemplate(stages ...map[string]interface{}) map[string]interface{} {
+	pipelineStages := make([]interface{}, 0, len(stages))
+	for _, eachStage := range stages {
+		pipelineStages = append(pipelineStages, eachStage)
+	}
+	return map[string]interface{}{
+		"Parameters": map[string]interface{}{
+			"TestStackName": map[string]interface{}{
+				"Type":    "String",
+				"Default": "Test-MyService-SpartaPipeline-master",
+			},
+		},
+		"Resources": map[string]interface{}{
+			"CodeBuildProject": map[string]interface{}{
+				"Type": "AWS::CodeBuild::Project",
+				"Properties": map[string]interface{}{
+					"Name": "CodeBuild-MyService-SpartaPipeline-master",
+				},
+			},
+			"Pipeline": map[string]interface{}{
+				"Type": "AWS::CodePipeline::Pipeline",
+				"Properties": map[string]interface{}{
+					"RoleArn": map[string]interface{}{"Fn::GetAtt": []interface{}{"CodeBuildProject", "Arn"}},
+					"Stages":  pipelineStages,
+				},
+			},
+		},
+		"Outputs": map[string]interface{}{
+			"ProjectName": map[string]interface{}{
+				"Value": map[string]interface{}{"Ref": "CodeBuildProject"},
+			},
+		},
+	}
+}
+
+// testDeployStage deploys the Template artifact with a change set
+func testDeployStage() map[string]interface{} {
+	createAction := testAction("CreateChangeSet", "CloudFormation", 1, []string{"Template"}, nil)
+	createAction["Configuration"] = map[string]interface{}{
+		"StackName":     map[string]interface{}{"Ref": "TestStackName"},
+		"ChangeSetName": "TestChangeSet-MyService-SpartaPipeline-master",
+	}
+	return testStage("TestStage",
+		createAction,
+		testAction("ExecuteChangeSet", "CloudFormation", 2, nil, nil))
+}
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template func() map[string]interface{}
+		expected []validationIssue
+	}{
+		{
+			name: "valid",
+			template: func() map[string]interface{} {
+				return testTemplate(testSourceStage(), testBuildStage(), testDeployStage())
+			},
+			expected: []validationIssue{},
+		},
+		{
+			name: "unresolved Ref",
+			template: func() map[string]interface{} {
+				template := testTemplate(testSourceStage(), testBuildStage())
+				template["Outputs"].(map[string]interface{})["Missing"] = map[string]interface{}{
+					"Value": map[string]interface{}{"Ref": "MissingResource"},
+				}
+				template["Outputs"].(map[string]interface{})["Region"] = map[string]interface{}{
+					"Value": map[string]interface{}{"Ref": "AWS::Region"},
+				}
+				return template
+			},
+			expected: []validationIssue{
+				{"Outputs.Missing.Value.Ref", "Unresolved Ref target: MissingResource", true},
+			},
+		},
+		{
+			name: "unresolved and malformed Fn::GetAtt",
+			template: func() map[string]interface{} {
+				template := testTemplate(testSourceStage(), testBuildStage())
+				outputs := template["Outputs"].(map[string]interface{})
+				outputs["Missing"] = map[string]interface{}{
+					"Value": map[string]interface{}{"Fn::GetAtt": []interface{}{"MissingResource", "Arn"}},
+				}
+				outputs["Malformed"] = map[string]interface{}{
+					"Value": map[string]interface{}{"Fn::GetAtt": []interface{}{"CodeBuildProject"}},
+				}
+				return template
+			},
+			expected: []validationIssue{
+				{"Outputs.Malformed.Value.Fn::GetAtt", "Fn::GetAtt requires [LogicalID, Attribute]", true},
+				{"Outputs.Missing.Value.Fn::GetAtt", "Unresolved Fn::GetAtt target: MissingResource", true},
+			},
+		},
+		{
+			name: "invalid logical ID",
+			template: func() map[string]interface{} {
+				template := testTemplate(testSourceStage(), testBuildStage())
+				template["Resources"].(map[string]interface{})["Invalid-Resource"] = map[string]interface{}{
+					"Type": "AWS::SNS::Topic",
+				}
+				return template
+			},
+			expected: []validationIssue{
+				{"Resources.Invalid-Resource", "Invalid logical resource ID: Invalid-Resource", true},
+			},
+		},
+		{
+			name: "CodeBuild project name",
+			template: func() map[string]interface{} {
+				template := testTemplate(testSourceStage(), testBuildStage())
+				project := template["Resources"].(map[string]interface{})["CodeBuildProject"].(map[string]interface{})
+				project["Properties"].(map[string]interface{})["Name"] = "CodeBuild my service"
+				return template
+			},
+			expected: []validationIssue{
+				{"Resources.CodeBuildProject.Properties.Name", "CodeBuild my service contains invalid characters", true},
+			},
+		},
+		{
+			name: "stack name too long",
+			template: func() map[string]interface{} {
+				template := testTemplate(testSourceStage(), testBuildStage())
+				param := template["Parameters"].(map[string]interface{})["TestStackName"].(map[string]interface{})
+				param["Default"] = "Test-" + strings.Repeat("a", maxStackNameLength)
+				return template
+			},
+			expected: []validationIssue{
+				{"Parameters.TestStackName.Default",
+					"Test-" + strings.Repeat("a", maxStackNameLength) + " exceeds the 128 character limit (133)",
+					true},
+			},
+		},
+		{
+			name: "invalid change set name",
+			template: func() map[string]interface{} {
+				deployStage := testDeployStage()
+				createAction := deployStage["Actions"].([]interface{})[0].(map[string]interface{})
+				createAction["Configuration"].(map[string]interface{})["ChangeSetName"] = "1-ChangeSet"
+				return testTemplate(testSourceStage(), testBuildStage(), deployStage)
+			},
+			expected: []validationIssue{
+				{"Resources.Pipeline.Properties.Stages[2].Actions[0].Configuration.ChangeSetName",
+					"1-ChangeSet contains invalid characters",
+					true},
+			},
+		},
+		{
+			name: "duplicate stage name and empty stage",
+			template: func() map[string]interface{} {
+				return testTemplate(testSourceStage(), testBuildStage(), testStage("Build"))
+			},
+			expected: []validationIssue{
+				{"Resources.Pipeline.Properties.Stages[2]", "Duplicate stage name: Build", true},
+				{"Resources.Pipeline.Properties.Stages[2]", "Stage Build has no actions", true},
+			},
+		},
+		{
+			name: "duplicate and long action names",
+			template: func() map[string]interface{} {
+				longName := strings.Repeat("a", maxPipelineActionLength+1)
+				return testTemplate(testSourceStage(),
+					testBuildStage(),
+					testStage("ProdStage",
+						testAction("Approve", "Manual", 1, nil, nil),
+						testAction("Approve", "Manual", 2, nil, nil),
+						testAction(longName, "Manual", 3, nil, nil)))
+			},
+			expected: []validationIssue{
+				{"Resources.Pipeline.Properties.Stages[2].Actions[1]", "Duplicate action name in stage ProdStage: Approve", true},
+				{"Resources.Pipeline.Properties.Stages[2].Actions[2]",
+					"Action name " + strings.Repeat("a", maxPipelineActionLength+1) + " exceeds the 100 character limit",
+					true},
+			},
+		},
+		{
+			name: "unknown action owner, category and provider",
+			template: func() map[string]interface{} {
+				unknownOwner := testAction("UnknownOwner", "Manual", 1, nil, nil)
+				unknownOwner["ActionTypeId"] = map[string]interface{}{"Owner": "Someone", "Category": "Approval", "Provider": "Manual"}
+				unknownCategory := testAction("UnknownCategory", "Manual", 1, nil, nil)
+				unknownCategory["ActionTypeId"] = map[string]interface{}{"Owner": "ThirdParty", "Category": "Build", "Provider": "Jenkins"}
+				unknownProvider := testAction("UnknownProvider", "Manual", 1, nil, nil)
+				unknownProvider["ActionTypeId"] = map[string]interface{}{"Owner": "AWS", "Category": "Approval", "Provider": "Slack"}
+				customAction := testAction("Custom", "Manual", 1, nil, nil)
+				customAction["ActionTypeId"] = map[string]interface{}{"Owner": "Custom", "Category": "Build", "Provider": "MyBuilder"}
+				return testTemplate(testSourceStage(),
+					testBuildStage(),
+					testStage("ProdStage", unknownOwner, unknownCategory, unknownProvider, customAction))
+			},
+			expected: []validationIssue{
+				{"Resources.Pipeline.Properties.Stages[2].Actions[0]", "Unknown action owner: Someone", true},
+				{"Resources.Pipeline.Properties.Stages[2].Actions[1]", "Unknown action category for ThirdParty owner: Build", true},
+				{"Resources.Pipeline.Properties.Stages[2].Actions[2]", "Unknown Approval action provider: Slack", true},
+			},
+		},
+		{
+			name: "input artifacts",
+			template: func() map[string]interface{} {
+				return testTemplate(testSourceStage(),
+					testStage("Build",
+						testAction("Build", "CodeBuild", 1, []string{"Source"}, []string{"Template"}),
+						testAction("Verify", "CodeBuild", 1, []string{"Template"}, nil),
+						testAction("Test", "CodeBuild", 2, []string{"Template", "Missing"}, nil)))
+			},
+			expected: []validationIssue{
+				{"Resources.Pipeline.Properties.Stages[1].Actions[1]", "Input artifact Template is not produced by an earlier action", true},
+				{"Resources.Pipeline.Properties.Stages[1].Actions[2]", "Input artifact Missing is not produced by an earlier action", true},
+			},
+		},
+		{
+			name: "duplicate output artifacts",
+			template: func() map[string]interface{} {
+				return testTemplate(testSourceStage(),
+					testStage("Build",
+						testAction("Build", "CodeBuild", 1, []string{"Source"}, []string{"Source"}),
+						testAction("Package", "CodeBuild", 1, []string{"Source"}, []string{"Template"}),
+						testAction("Repackage", "CodeBuild", 2, []string{"Source"}, []string{"Template"})))
+			},
+			expected: []validationIssue{
+				{"Resources.Pipeline.Properties.Stages[1].Actions[0]", "Output artifact Source is already produced by an earlier action", true},
+				{"Resources.Pipeline.Properties.Stages[1].Actions[2]", "Output artifact Template is already produced by an earlier action", true},
+			},
+		},
+		{
+			name: "RunOrder gap",
+			template: func() map[string]interface{} {
+				return testTemplate(testSourceStage(),
+					testBuildStage(),
+					testStage("ProdStage",
+						testAction("Approve", "Manual", 1, nil, nil),
+						testAction("Deploy", "CloudFormation", 3, []string{"Template"}, nil)))
+			},
+			expected: []validationIssue{
+				{"Resources.Pipeline.Properties.Stages[2]", "Stage ProdStage RunOrder values have a gap at 2", false},
+			},
+		},
+	}
+	for _, eachTest := range tests {
+		t.Run(eachTest.name, func(t *testing.T) {
+			jsonBytes, jsonBytesErr := json.Marshal(eachTest.template())
+			if jsonBytesErr != nil {
+				t.Fatal(jsonBytesErr)
+			}
+			issues, issuesErr := validateTemplateJSON(jsonBytes)
+			if issuesErr != nil {
+				t.Fatal(issuesErr)
+			}
+			if !reflect.DeepEqual(issues, eachTest.expected) {
+				t.Errorf("Issues:\n%+v\nexpected:\n%+v", issues, eachTest.expected)
+			}
+		})
+	}
+}